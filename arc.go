@@ -5,20 +5,39 @@ import (
 	"time"
 )
 
+// ARCInspector exposes the ghost lists of a cache built with ARC(). Reach it
+// with a type assertion:
+//
+//	if arc, ok := gc.(gcache.ARCInspector); ok {
+//		arc.Forget("key")
+//	}
+type ARCInspector interface {
+	// GhostLen returns the combined length of the ghost lists.
+	GhostLen() int
+	// TrackedLen returns the number of keys tracked by the cache, including
+	// the keys in the ghost lists.
+	TrackedLen() int
+	// Forget removes key from the cache like Remove, and also erases it from
+	// the ghost lists. It reports whether key was in the cache.
+	Forget(key interface{}) bool
+}
+
 // Constantly balances between LRU and LFU, to improve the combined result.
 type arcCache struct {
 	baseCache
 	items map[interface{}]*cacheItem
 
-	part int
-	t1   *arcList
-	t2   *arcList
-	b1   *arcList
-	b2   *arcList
+	part       int
+	ghostLimit int
+	maxTracked int
+	t1         *arcList
+	t2         *arcList
+	b1         *arcList
+	b2         *arcList
 }
 
 func newARC(cb *CacheBuilder) *arcCache {
-	c := &arcCache{}
+	c := &arcCache{ghostLimit: cb.arcGhostLimit, maxTracked: cb.arcMaxTracked}
 	buildCache(&c.baseCache, c, cb)
 
 	c.init()
@@ -32,6 +51,7 @@ func (c *arcCache) init() {
 	c.t2 = newARCList()
 	c.b1 = newARCList()
 	c.b2 = newARCList()
+	c.peakItems = 0
 }
 
 func (c *arcCache) replace(key interface{}) {
@@ -39,61 +59,91 @@ func (c *arcCache) replace(key interface{}) {
 		return
 	}
 	var old interface{}
+	var ok bool
 	if c.t1.Len() > 0 && ((c.b2.Has(key) && c.t1.Len() == c.part) || (c.t1.Len() > c.part)) {
-		old = c.t1.RemoveTail()
-		c.b1.PushFront(old)
+		if old, ok = c.t1.RemoveTail(); ok {
+			c.b1.PushFront(old)
+		}
 	} else if c.t2.Len() > 0 {
-		old = c.t2.RemoveTail()
-		c.b2.PushFront(old)
-	} else {
-		old = c.t1.RemoveTail()
+		if old, ok = c.t2.RemoveTail(); ok {
+			c.b2.PushFront(old)
+		}
+	} else if old, ok = c.t1.RemoveTail(); ok {
 		c.b1.PushFront(old)
 	}
+	if !ok {
+		return
+	}
 	item, ok := c.items[old]
 	if ok {
+		c.recordVictim(item)
 		delete(c.items, old)
-		if c.evictedFunc != nil {
-			c.evictedFunc(item.key, item.value)
-		}
+		c.notifyEvicted(item.key, item.value)
 	}
 }
 
 func (c *arcCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, err := c.serialize(key, value)
+	if err != nil {
+		return nil, err
 	}
 
 	item, ok := c.items[key]
 	if ok {
-		item.value = value
+		item.value = c.soft(value)
 	} else {
 		item = &cacheItem{
 			clock: c.clock,
 			key:   key,
-			value: value,
+			value: c.soft(value),
 		}
+		c.stampInserted(item)
 		c.items[key] = item
+		c.trackPeak(len(c.items))
 	}
 
 	if c.expiration != nil {
 		t := c.clock.Now().Add(*c.expiration)
 		item.expiration = &t
 	}
+	item.setMaxIdle(c.maxIdle)
+	item.version = c.nextVersion()
 
 	defer func() {
-		if c.addedFunc != nil {
-			c.addedFunc(key, value)
-		}
+		c.trimGhosts()
+		c.stats.observeLen(len(c.items))
+		c.recordSet(key)
+		c.notifyAdded(key, value)
 	}()
 
-	if c.t1.Has(key) || c.t2.Has(key) {
+	if elt := c.t1.Lookup(key); elt != nil {
+		if c.setCountsAsAccess {
+			c.t1.Remove(key, elt)
+			c.t2.PushFront(key)
+		}
+		return item, nil
+	}
+	if elt := c.t2.Lookup(key); elt != nil {
+		if c.setCountsAsAccess {
+			c.t2.MoveToFront(elt)
+		}
 		return item, nil
 	}
 
+	if c.evictionPauses > 0 {
+		if elt := c.b1.Lookup(key); elt != nil {
+			c.b1.Remove(key, elt)
+		} else if elt := c.b2.Lookup(key); elt != nil {
+			c.b2.Remove(key, elt)
+		}
+		c.t1.PushFront(key)
+		return item, nil
+	}
+
+	if c.evictExpiredFirst && c.isCacheFull() {
+		c.evictExpired()
+	}
+
 	if elt := c.b1.Lookup(key); elt != nil {
 		c.setPart(minInt(c.size, c.part+maxInt(c.b2.Len()/c.b1.Len(), 1)))
 		c.replace(key)
@@ -110,18 +160,17 @@ func (c *arcCache) set(key, value interface{}) (interface{}, error) {
 		return item, nil
 	}
 
-	if c.isCacheFull() && c.t1.Len()+c.b1.Len() == c.size {
+	if c.t1.Len()+c.b1.Len() >= c.size {
 		if c.t1.Len() < c.size {
 			c.b1.RemoveTail()
 			c.replace(key)
 		} else {
-			pop := c.t1.RemoveTail()
+			pop, _ := c.t1.RemoveTail()
 			item, ok := c.items[pop]
 			if ok {
+				c.recordVictim(item)
 				delete(c.items, pop)
-				if c.evictedFunc != nil {
-					c.evictedFunc(item.key, item.value)
-				}
+				c.notifyEvicted(item.key, item.value)
 			}
 		}
 	} else {
@@ -146,62 +195,64 @@ func (c *arcCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	if c.deserializeFunc != nil {
-		return c.deserializeFunc(key, v)
+	v, err = c.deserialize(key, v)
+	if !onLoad {
+		c.recordGet(key, err == nil)
 	}
-	return v, nil
+	return v, err
 }
 
 func (c *arcCache) getValue(key interface{}, onLoad bool) (interface{}, error) {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
+	defer c.trimGhosts()
 	if elt := c.t1.Lookup(key); elt != nil {
-		c.t1.Remove(key, elt)
 		item := c.items[key]
-		if !item.IsExpired(nil) {
+		if v, ok := item.liveValue(nil); ok {
+			c.touch(item)
+			c.t1.Remove(key, elt)
 			c.t2.PushFront(key)
-			if !onLoad {
-				c.stats.IncrHitCount()
-			}
-			return item.value, nil
+			return v, nil
 		}
 
-		delete(c.items, key)
-		c.b1.PushFront(key)
-		if c.evictedFunc != nil {
-			c.evictedFunc(item.key, item.value)
+		if !c.lazyExpireDisabled {
+			c.t1.Remove(key, elt)
+			delete(c.items, key)
+			c.b1.PushFront(key)
+			c.notifyEvicted(item.key, item.value)
+			c.notifyExpired(item.key, item.value)
 		}
 	}
 	if elt := c.t2.Lookup(key); elt != nil {
 		item := c.items[key]
-		if !item.IsExpired(nil) {
+		if v, ok := item.liveValue(nil); ok {
+			c.touch(item)
 			c.t2.MoveToFront(elt)
-			if !onLoad {
-				c.stats.IncrHitCount()
-			}
-			return item.value, nil
+			return v, nil
 		}
 
-		delete(c.items, key)
-		c.t2.Remove(key, elt)
-		c.b2.PushFront(key)
-		if c.evictedFunc != nil {
-			c.evictedFunc(item.key, item.value)
+		if !c.lazyExpireDisabled {
+			delete(c.items, key)
+			c.t2.Remove(key, elt)
+			c.b2.PushFront(key)
+			c.notifyEvicted(item.key, item.value)
+			c.notifyExpired(item.key, item.value)
 		}
 	}
 
 	if !onLoad {
-		c.stats.IncrMissCount()
+		c.recordGet(key, false)
 	}
 	return nil, ErrKeyNotFound
 }
 
 // Has checks if key exists in cache
 func (c *arcCache) Existed(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	now := time.Now()
-	return c.has(key, &now)
+	now := c.clock.Now()
+	return c.existed(c.has(key, &now))
 }
 
 func (c *arcCache) has(key interface{}, now *time.Time) bool {
@@ -212,23 +263,52 @@ func (c *arcCache) has(key interface{}, now *time.Time) bool {
 	return !item.IsExpired(now)
 }
 
+// peek returns the item for key without updating the ARC lists, or nil.
+func (c *arcCache) peek(key interface{}) *cacheItem {
+	return c.items[key]
+}
+
+// access records a read of the item for key, promoting it like a hit.
+func (c *arcCache) access(key interface{}) {
+	item, ok := c.items[key]
+	if !ok {
+		return
+	}
+	c.touch(item)
+	if elt := c.t1.Lookup(key); elt != nil {
+		c.t1.Remove(key, elt)
+		c.t2.PushFront(key)
+	} else if elt := c.t2.Lookup(key); elt != nil {
+		c.t2.MoveToFront(elt)
+	}
+}
+
+// forEach calls fn for every item until fn returns false.
+func (c *arcCache) forEach(fn func(item *cacheItem) bool) {
+	for _, item := range c.items {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
 // Remove removes the provided key from the cache.
 func (c *arcCache) Remove(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	return c.remove(key)
+	return c.recordRemove(key, c.remove(key))
 }
 
 func (c *arcCache) remove(key interface{}) bool {
+	defer c.trimGhosts()
 	if elt := c.t1.Lookup(key); elt != nil {
 		c.t1.Remove(key, elt)
 		item := c.items[key]
 		delete(c.items, key)
 		c.b1.PushFront(key)
-		if c.evictedFunc != nil {
-			c.evictedFunc(key, item.value)
-		}
+		c.notifyEvicted(key, item.value)
 		return true
 	}
 
@@ -237,27 +317,38 @@ func (c *arcCache) remove(key interface{}) bool {
 		item := c.items[key]
 		delete(c.items, key)
 		c.b2.PushFront(key)
-		if c.evictedFunc != nil {
-			c.evictedFunc(key, item.value)
-		}
+		c.notifyEvicted(key, item.value)
 		return true
 	}
 
 	return false
 }
 
-// GetALL returns all key-value pairs in the cache.
-func (c *arcCache) GetALL(checkExpired bool) map[interface{}]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	items := make(map[interface{}]interface{}, len(c.items))
-	now := time.Now()
-	for k, item := range c.items {
-		if !checkExpired || c.has(k, &now) {
-			items[k] = item.value
+// Forget removes key from the cache like Remove, and also erases it from the
+// ghost lists, so that setting it again treats it as a brand new key instead
+// of a recently evicted one. It reports whether key was in the cache.
+func (c *arcCache) Forget(key interface{}) bool {
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+
+	if elt := c.b1.Lookup(key); elt != nil {
+		c.b1.Remove(key, elt)
+	}
+	if elt := c.b2.Lookup(key); elt != nil {
+		c.b2.Remove(key, elt)
+	}
+	var ok bool
+	for _, l := range []*arcList{c.t1, c.t2} {
+		if elt := l.Lookup(key); elt != nil {
+			l.Remove(key, elt)
+			item := c.items[key]
+			delete(c.items, key)
+			c.notifyEvicted(key, item.value)
+			ok = true
 		}
 	}
-	return items
+	return c.recordRemove(key, ok)
 }
 
 // Keys returns a slice of the keys in the cache.
@@ -265,10 +356,10 @@ func (c *arcCache) Keys(checkExpired bool) []interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	keys := make([]interface{}, 0, len(c.items))
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if !checkExpired || c.has(k, &now) {
-			keys = append(keys, k)
+			keys = append(keys, c.decodeKey(k))
 		}
 	}
 	return keys
@@ -282,7 +373,7 @@ func (c *arcCache) Len(checkExpired bool) int {
 		return len(c.items)
 	}
 	var length int
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if c.has(k, &now) {
 			length++
@@ -294,25 +385,135 @@ func (c *arcCache) Len(checkExpired bool) int {
 // Purge is used to completely clear the cache
 func (c *arcCache) Purge() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	if c.purgeVisitorFunc != nil {
 		for _, item := range c.items {
-			c.purgeVisitorFunc(item.key, item.value)
+			c.notifyPurged(item.key, item.value)
 		}
 	}
 
+	c.tags = nil
+	c.order = insertionOrder{}
+	c.clearLoaderErrors()
 	c.init()
 }
 
+// Compact rebuilds the items map and the key indexes of the ARC lists if the
+// cache has shrunk far below its peak size. List order is preserved.
+func (c *arcCache) Compact() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.needCompact(len(c.items)) {
+		return
+	}
+	items := make(map[interface{}]*cacheItem, len(c.items))
+	for k, item := range c.items {
+		items[k] = item
+	}
+	c.items = items
+	c.peakItems = len(items)
+
+	c.t1.compact()
+	c.t2.compact()
+	c.b1.compact()
+	c.b2.compact()
+}
+
+// GhostLen returns the combined length of the ghost lists.
+func (c *arcCache) GhostLen() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.b1.Len() + c.b2.Len()
+}
+
+// TrackedLen returns the number of keys tracked by the cache,
+// including the keys in the ghost lists.
+func (c *arcCache) TrackedLen() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.t1.Len() + c.t2.Len() + c.b1.Len() + c.b2.Len()
+}
+
+// ghostCap returns the maximum combined length of the ghost lists allowed by
+// ghostLimit and maxTracked, or -1 if it is unbounded.
+func (c *arcCache) ghostCap() int {
+	limit := -1
+	if c.ghostLimit > 0 {
+		limit = c.ghostLimit
+	}
+	if c.maxTracked > 0 {
+		n := maxInt(0, c.maxTracked-c.t1.Len()-c.t2.Len())
+		if limit < 0 || n < limit {
+			limit = n
+		}
+	}
+	return limit
+}
+
+// trimGhosts drops the oldest ghost entries, taken from the longer ghost list,
+// until the ghost lists fit within ghostCap.
+func (c *arcCache) trimGhosts() {
+	limit := c.ghostCap()
+	if limit < 0 {
+		return
+	}
+	for c.b1.Len()+c.b2.Len() > limit {
+		if c.b1.Len() >= c.b2.Len() {
+			c.b1.RemoveTail()
+		} else {
+			c.b2.RemoveTail()
+		}
+	}
+}
+
 func (c *arcCache) setPart(p int) {
 	if c.isCacheFull() {
 		c.part = p
 	}
 }
 
+// evictOverflow evicts the items exceeding the size of the cache, moving
+// them to the ghost lists like a regular replacement.
+func (c *arcCache) evictOverflow() {
+	for c.t1.Len()+c.t2.Len() > c.size {
+		c.replace(nil)
+	}
+	for c.b1.Len()+c.b2.Len() > c.size {
+		if c.b1.Len() >= c.b2.Len() {
+			c.b1.RemoveTail()
+		} else {
+			c.b2.RemoveTail()
+		}
+	}
+	c.trimGhosts()
+}
+
+// evictExpired removes an expired item, if there is one among the least
+// recently used items of t1 and t2. Unlike an eviction it leaves no ghost entry,
+// since the item left the cache regardless of the policy.
+func (c *arcCache) evictExpired() {
+	now := c.clock.Now()
+	probed := 0
+	for _, l := range []*arcList{c.t1, c.t2} {
+		for elt := l.l.Back(); elt != nil && probed < expiredProbeLimit; elt = elt.Prev() {
+			probed++
+			key := elt.Value
+			item := c.items[key]
+			if item.IsExpired(&now) {
+				l.Remove(key, elt)
+				delete(c.items, key)
+				c.notifyEvicted(key, item.value)
+				c.notifyExpired(key, item.value)
+				return
+			}
+		}
+	}
+}
+
 func (c *arcCache) isCacheFull() bool {
-	return (c.t1.Len() + c.t2.Len()) == c.size
+	return (c.t1.Len() + c.t2.Len()) >= c.size
 }
 
 type arcList struct {
@@ -355,14 +556,27 @@ func (al *arcList) Remove(key interface{}, elt *list.Element) {
 	al.l.Remove(elt)
 }
 
-func (al *arcList) RemoveTail() interface{} {
+// RemoveTail removes the last key and returns it, or false if the list is empty.
+func (al *arcList) RemoveTail() (interface{}, bool) {
 	elt := al.l.Back()
+	if elt == nil {
+		return nil, false
+	}
 	al.l.Remove(elt)
 
 	key := elt.Value
 	delete(al.keys, key)
 
-	return key
+	return key, true
+}
+
+// compact rebuilds the key index into a right-sized map.
+func (al *arcList) compact() {
+	keys := make(map[interface{}]*list.Element, len(al.keys))
+	for k, elt := range al.keys {
+		keys[k] = elt
+	}
+	al.keys = keys
 }
 
 func (al *arcList) Len() int {
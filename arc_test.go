@@ -2,6 +2,7 @@ package gcache
 
 import (
 	"fmt"
+	"math/rand"
 	"testing"
 	"time"
 )
@@ -112,3 +113,235 @@ func TestARCHas(t *testing.T) {
 		})
 	}
 }
+
+// checkInvariants verifies the structural invariants of the ARC lists.
+func (c *arcCache) checkInvariants() error {
+	t1, t2, b1, b2 := c.t1.Len(), c.t2.Len(), c.b1.Len(), c.b2.Len()
+	sizes := fmt.Sprintf("size=%d part=%d t1=%d t2=%d b1=%d b2=%d items=%d", c.size, c.part, t1, t2, b1, b2, len(c.items))
+	if t1+t2 > c.size {
+		return fmt.Errorf("t1+t2 exceeds size: %s", sizes)
+	}
+	if t1+b1 > c.size {
+		return fmt.Errorf("t1+b1 exceeds size: %s", sizes)
+	}
+	if t1+t2+b1+b2 > 2*c.size {
+		return fmt.Errorf("t1+t2+b1+b2 exceeds 2*size: %s", sizes)
+	}
+	if c.part < 0 || c.part > c.size {
+		return fmt.Errorf("part out of range: %s", sizes)
+	}
+	if len(c.items) != t1+t2 {
+		return fmt.Errorf("items do not match t1+t2: %s", sizes)
+	}
+	lists := []struct {
+		name string
+		l    *arcList
+	}{{"t1", c.t1}, {"t2", c.t2}, {"b1", c.b1}, {"b2", c.b2}}
+	seen := make(map[interface{}]string)
+	for _, al := range lists {
+		if len(al.l.keys) != al.l.Len() {
+			return fmt.Errorf("%s index has %d keys for %d elements: %s", al.name, len(al.l.keys), al.l.Len(), sizes)
+		}
+		for e := al.l.l.Front(); e != nil; e = e.Next() {
+			if other, ok := seen[e.Value]; ok {
+				return fmt.Errorf("key %v is in both %s and %s: %s", e.Value, other, al.name, sizes)
+			}
+			seen[e.Value] = al.name
+			if al.l.keys[e.Value] != e {
+				return fmt.Errorf("%s index is stale for key %v: %s", al.name, e.Value, sizes)
+			}
+			_, ok := c.items[e.Value]
+			if ghost := al.name[0] == 'b'; ghost == ok {
+				return fmt.Errorf("key %v in %s has item=%v: %s", e.Value, al.name, ok, sizes)
+			}
+		}
+	}
+	return nil
+}
+
+func TestARCInvariants(t *testing.T) {
+	for seed := int64(0); seed < 50; seed++ {
+		rnd := rand.New(rand.NewSource(seed))
+		size := 1 + rnd.Intn(8)
+		fc := NewFakeClock()
+		gc := New(size).ARC().Clock(fc).Build()
+		c := gc.(*arcCache)
+		for i := 0; i < 2000; i++ {
+			key := rnd.Intn(size * 3)
+			var op string
+			switch rnd.Intn(6) {
+			case 0, 1:
+				op = "Set"
+				gc.Set(key, key)
+			case 2:
+				op = "Get"
+				gc.GetIFPresent(key)
+			case 3:
+				op = "Remove"
+				gc.Remove(key)
+			case 4:
+				op = "SetWithExpire"
+				gc.SetWithExpire(key, key, time.Duration(rnd.Intn(3))*time.Second)
+				fc.Advance(time.Second)
+			case 5:
+				op = "Forget"
+				c.Forget(key)
+			}
+			if err := c.checkInvariants(); err != nil {
+				t.Fatalf("seed=%d step=%d %s(%v): %v", seed, i, op, key, err)
+			}
+		}
+	}
+}
+
+func TestARCSmallSizes(t *testing.T) {
+	for size := 1; size <= 4; size++ {
+		for seed := int64(0); seed < 100; seed++ {
+			rnd := rand.New(rand.NewSource(seed))
+			gc := New(size).ARC().Build()
+			c := gc.(*arcCache)
+			for i := 0; i < 500; i++ {
+				key := rnd.Intn(size * 2)
+				var op string
+				switch rnd.Intn(3) {
+				case 0:
+					op = "Set"
+					gc.Set(key, key)
+				case 1:
+					op = "Get"
+					gc.GetIFPresent(key)
+				case 2:
+					op = "Remove"
+					gc.Remove(key)
+				}
+				if err := c.checkInvariants(); err != nil {
+					t.Fatalf("size=%d seed=%d step=%d %s(%v): %v", size, seed, i, op, key, err)
+				}
+			}
+		}
+	}
+}
+
+func TestARCListRemoveTailEmpty(t *testing.T) {
+	al := newARCList()
+	if key, ok := al.RemoveTail(); ok || key != nil {
+		t.Errorf("%v, %v != nil, false", key, ok)
+	}
+	al.PushFront("a")
+	if key, ok := al.RemoveTail(); !ok || key != "a" {
+		t.Errorf("%v, %v != a, true", key, ok)
+	}
+
+	// replace is a no-op when there is nothing to evict.
+	gc := New(1).ARC().Build()
+	gc.(*arcCache).replace("a")
+}
+
+func TestARCForget(t *testing.T) {
+	gc := New(4).ARC().Build()
+	c := gc.(*arcCache)
+
+	// A removed key is remembered, so setting it again promotes it to t2.
+	gc.Set("a", 1)
+	gc.Remove("a")
+	if !c.b1.Has("a") {
+		t.Fatal("a removed key should be in b1")
+	}
+	gc.Set("a", 1)
+	if !c.t2.Has("a") {
+		t.Error("a recently removed key should be promoted to t2")
+	}
+
+	if !c.Forget("a") {
+		t.Error("a cached key should be forgotten")
+	}
+	if c.b1.Has("a") || c.b2.Has("a") || gc.Existed("a") {
+		t.Error("a forgotten key should leave no trace")
+	}
+	gc.Set("a", 1)
+	if !c.t1.Has("a") {
+		t.Error("a forgotten key should be set as a new key in t1")
+	}
+
+	// Forgetting a key only in a ghost list erases it too.
+	gc.Remove("a")
+	if c.Forget("a") {
+		t.Error("a key only in a ghost list is not in the cache")
+	}
+	if c.b1.Has("a") {
+		t.Error("a forgotten key should not stay in b1")
+	}
+}
+
+func TestARCGhostLimit(t *testing.T) {
+	size := 100
+	limit := 10
+	run := func(ghostLimit int) float64 {
+		rnd := rand.New(rand.NewSource(1))
+		zipf := rand.NewZipf(rnd, 1.1, 1, uint64(size*20))
+		gc := New(size).ARC().ARCGhostLimit(ghostLimit).Build()
+		c := gc.(*arcCache)
+		for i := 0; i < 20000; i++ {
+			key := zipf.Uint64()
+			if _, err := gc.GetIFPresent(key); err == ErrKeyNotFound {
+				gc.Set(key, key)
+			}
+			if ghostLimit > 0 && c.GhostLen() > ghostLimit {
+				t.Fatalf("%v > %v", c.GhostLen(), ghostLimit)
+			}
+			if err := c.checkInvariants(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return gc.HitRate()
+	}
+
+	unlimited := run(0)
+	limited := run(limit)
+	if limited < unlimited/2 {
+		t.Errorf("hit rate dropped from %v to %v", unlimited, limited)
+	}
+}
+
+func TestARCMaxTracked(t *testing.T) {
+	size := 10
+	maxTracked := 15
+	rnd := rand.New(rand.NewSource(1))
+	gc := New(size).ARC().ARCMaxTracked(maxTracked).Build()
+	c := gc.(*arcCache)
+	for i := 0; i < 5000; i++ {
+		key := rnd.Intn(size * 5)
+		if _, err := gc.GetIFPresent(key); err == ErrKeyNotFound {
+			gc.Set(key, key)
+		}
+		if l := c.TrackedLen(); l > maxTracked {
+			t.Fatalf("%v > %v", l, maxTracked)
+		}
+		if err := c.checkInvariants(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if l := gc.Len(false); l != size {
+		t.Errorf("%v != %v", l, size)
+	}
+}
+
+func TestARCInspector(t *testing.T) {
+	gc := New(2).ARC().Build()
+	arc, ok := gc.(ARCInspector)
+	if !ok {
+		t.Fatal("an ARC cache should implement ARCInspector")
+	}
+	gc.Set(0, 0)
+	gc.Set(1, 1)
+	gc.Remove(0)
+	if n := arc.GhostLen(); n != 1 {
+		t.Errorf("%v != %v", n, 1)
+	}
+	if n := arc.TrackedLen(); n != 2 {
+		t.Errorf("%v != %v", n, 2)
+	}
+	if !arc.Forget(1) || gc.Existed(1) {
+		t.Error("Forget should remove a cached key")
+	}
+}
@@ -0,0 +1,66 @@
+package gcache
+
+import (
+	"sync"
+	"time"
+)
+
+// Operations recorded in the audit log.
+const (
+	AuditGet    = "get"
+	AuditSet    = "set"
+	AuditRemove = "remove"
+	AuditEvict  = "evict"
+)
+
+// Results recorded in the audit log.
+const (
+	AuditHit      = "hit"
+	AuditMiss     = "miss"
+	AuditOK       = "ok"
+	AuditNotFound = "not found"
+)
+
+// AuditEntry is a single operation recorded in the audit log.
+type AuditEntry struct {
+	Time   time.Time
+	Op     string
+	Key    interface{}
+	Result string
+}
+
+// auditLog is a fixed size ring of the most recent operations.
+// It has its own mutex so recording never contends with the cache lock.
+type auditLog struct {
+	mu      sync.Mutex
+	entries []AuditEntry
+	next    int
+	full    bool
+}
+
+func newAuditLog(size int) *auditLog {
+	return &auditLog{entries: make([]AuditEntry, size)}
+}
+
+func (al *auditLog) record(entry AuditEntry) {
+	al.mu.Lock()
+	al.entries[al.next] = entry
+	al.next++
+	if al.next == len(al.entries) {
+		al.next = 0
+		al.full = true
+	}
+	al.mu.Unlock()
+}
+
+// snapshot returns the recorded entries, oldest first.
+func (al *auditLog) snapshot() []AuditEntry {
+	al.mu.Lock()
+	defer al.mu.Unlock()
+	if !al.full {
+		return append([]AuditEntry(nil), al.entries[:al.next]...)
+	}
+	entries := make([]AuditEntry, 0, len(al.entries))
+	entries = append(entries, al.entries[al.next:]...)
+	return append(entries, al.entries[:al.next]...)
+}
@@ -0,0 +1,73 @@
+package gcache
+
+import (
+	"testing"
+)
+
+func TestAuditLog(t *testing.T) {
+	cc := New(2).
+		LRU().
+		AuditLog(4).
+		Build()
+	if ops := cc.RecentOps(); len(ops) != 0 {
+		t.Fatalf("%v != %v", len(ops), 0)
+	}
+
+	cc.Set("a", 1)
+	cc.Set("b", 2)
+	cc.GetIFPresent("a")
+	cc.GetIFPresent("c")
+	if ops := cc.RecentOps(); len(ops) != 4 {
+		t.Fatalf("%v != %v", len(ops), 4)
+	}
+	cc.Set("c", 3)
+	cc.Remove("a")
+
+	expected := []AuditEntry{
+		{Op: AuditEvict, Key: "b", Result: AuditOK},
+		{Op: AuditSet, Key: "c", Result: AuditOK},
+		{Op: AuditEvict, Key: "a", Result: AuditOK},
+		{Op: AuditRemove, Key: "a", Result: AuditOK},
+	}
+	ops := cc.RecentOps()
+	if len(ops) != len(expected) {
+		t.Fatalf("%v != %v", len(ops), len(expected))
+	}
+	for i, op := range ops {
+		if op.Op != expected[i].Op || op.Key != expected[i].Key || op.Result != expected[i].Result {
+			t.Errorf("ops[%d]: %v != %v", i, op, expected[i])
+		}
+		if op.Time.IsZero() {
+			t.Errorf("ops[%d]: time is not set", i)
+		}
+	}
+}
+
+func TestAuditLogGets(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		cc := New(8).
+			EvictType(tp).
+			AuditLog(8).
+			Build()
+		cc.Set(0, 0)
+		cc.GetIFPresent(0)
+		cc.GetIFPresent(1)
+		cc.Remove(1)
+
+		expected := []AuditEntry{
+			{Op: AuditSet, Key: 0, Result: AuditOK},
+			{Op: AuditGet, Key: 0, Result: AuditHit},
+			{Op: AuditGet, Key: 1, Result: AuditMiss},
+			{Op: AuditRemove, Key: 1, Result: AuditNotFound},
+		}
+		ops := cc.RecentOps()
+		if len(ops) != len(expected) {
+			t.Fatalf("%v: %v != %v", tp, len(ops), len(expected))
+		}
+		for i, op := range ops {
+			if op.Op != expected[i].Op || op.Key != expected[i].Key || op.Result != expected[i].Result {
+				t.Errorf("%v: ops[%d]: %v != %v", tp, i, op, expected[i])
+			}
+		}
+	}
+}
@@ -4,7 +4,11 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"math"
+	"reflect"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,41 +19,299 @@ const (
 	TypeArc    = "arc"
 )
 
+// compactRatio is how many times the peak number of items has to exceed the
+// current number of items before Compact rebuilds the maps.
+const compactRatio = 4
+
+// expiredProbeLimit is how many eviction candidates are checked for expired
+// items before a live item is evicted, see EvictExpiredFirst.
+const expiredProbeLimit = 8
+
 // ErrKeyNotFound return error if key not found or expired
 var ErrKeyNotFound = errors.New("key not found")
 
+// ErrLoaderRecursion is returned when a loader tries to load the key it is loading.
+var ErrLoaderRecursion = errors.New("loader recursion")
+
+// ErrNotANumber is returned by Increment and Decrement for a value which is
+// neither an int, an int64 nor a float64 holding a whole number.
+var ErrNotANumber = errors.New("value is not a number")
+
+// ErrOverflow is returned by Increment and Decrement for a result which does
+// not fit in the type of the value, or in an int64.
+var ErrOverflow = errors.New("numeric overflow")
+
+// ErrKeyTypeMismatch is returned for a key whose type differs from the one
+// enforced with EnforceKeyType.
+var ErrKeyTypeMismatch = errors.New("key type mismatch")
+
 type Cache interface {
 	// Set a new key-value pair
 	Set(key, value interface{}) error
 
-	// SetWithExpire Set a new key-value pair with an expiration time
+	// SetWithExpire Set a new key-value pair with an expiration time.
+	// A zero expiration means the item never expires, and a negative one
+	// means it is already expired, so the key is removed instead of stored.
 	SetWithExpire(key, value interface{}, expiration time.Duration) error
 
+	// SetWithExpireAt Set a new key-value pair which expires at expireAt.
+	// A zero expireAt means the item never expires. Unlike SetWithExpire, an
+	// expireAt in the past still stores the item, which is then expired on
+	// the next access.
+	SetWithExpireAt(key, value interface{}, expireAt time.Time) error
+
+	// SetAll sets every key-value pair of entries like Set, but holding the
+	// lock only once. It stops at the first key which fails, in no particular
+	// order, and returns a *MultiError holding it. The pairs set before stay.
+	SetAll(entries map[interface{}]interface{}) error
+
+	// SetAllWithExpire sets entries like SetAll with the expiration of
+	// SetWithExpire.
+	SetAllWithExpire(entries map[interface{}]interface{}, expiration time.Duration) error
+
 	// GetIFPresent gets a value from cache pool using key if it exists.
 	// If it dose not exists key, returns ErrKeyNotFound.
 	// And send a request which refresh value for specified key if cache object has LoaderFunc.
 	GetIFPresent(key interface{}) (interface{}, error)
 
-	// GetALL returns all key-value pairs in the cache.
+	// GetIFPresentMulti gets the values of the keys which are present. Unlike
+	// GetIFPresent, it never loads. Each key counts as a hit or a miss. Keys
+	// whose value fails to deserialize are left out and reported in a
+	// *MultiError.
+	GetIFPresentMulti(keys []interface{}) (map[interface{}]interface{}, error)
+
+	// GetALL returns all key-value pairs in the cache. Values which fail to
+	// deserialize are omitted.
 	GetALL(checkExpired bool) map[interface{}]interface{}
 
 	// Remove removes the provided key from the cache.
 	Remove(key interface{}) bool
 
+	// RemoveAll removes keys like Remove, but holding the lock only once, and
+	// returns the number of keys removed.
+	RemoveAll(keys ...interface{}) int
+
+	// Pop removes key like Remove and returns its value, or ErrKeyNotFound if
+	// the key is missing or expired. Like Remove, it calls evictedFunc. An
+	// expired key is removed as well.
+	Pop(key interface{}) (interface{}, error)
+
 	// Completely clear the cache
 	Purge()
 
 	// Keys returns a slice of the keys in the cache.
 	Keys(checkExpired bool) []interface{}
 
+	// KeysPage lists the keys a page at a time, in the order they were
+	// inserted, for every type. It returns up to limit keys inserted after
+	// those of the page cursor was returned with, the cursor of the next page,
+	// which is 0 after the last one, and the number of items in the cache.
+	// Pass 0 for the first page. Each page takes the read lock only for the
+	// keys it returns. Reads and sets of existing keys do not change the
+	// order, so a key present during the whole listing is returned exactly
+	// once; keys inserted meanwhile come last, and a key removed and inserted
+	// again may be returned twice.
+	KeysPage(checkExpired bool, cursor uint64, limit int) (keys []interface{}, next uint64, total int)
+
+	// GetAllByPrefix returns the key-value pairs whose key is a string starting
+	// with prefix. Values which fail to deserialize are omitted.
+	GetAllByPrefix(prefix string, checkExpired bool) map[string]interface{}
+
+	// RemoveByPrefix removes the items whose key is a string starting with
+	// prefix and returns the number of items removed.
+	RemoveByPrefix(prefix string) int
+
+	// RemoveIf removes the live items for which pred returns true and returns
+	// the number of items removed. pred gets the deserialized value, and items
+	// whose value fails to deserialize are kept. pred runs with the cache
+	// locked, so it must not use the cache.
+	RemoveIf(pred func(key, value interface{}) bool) int
+
+	// SetWithTags sets a new key-value pair and replaces the tags of key.
+	// Set keeps the tags of an existing key.
+	SetWithTags(key, value interface{}, tags ...string) error
+
+	// InvalidateTag removes the items set with tag and returns the number of
+	// items removed.
+	InvalidateTag(tag string) int
+
+	// Values returns a slice of the values in the cache, in no particular order.
+	// Values which fail to deserialize are omitted.
+	Values(checkExpired bool) []interface{}
+
 	// Len returns the number of items in the cache.
 	Len(checkExpired bool) int
 
+	// ExpiredKeys returns the keys of the expired items which have not been
+	// removed yet, without removing them.
+	ExpiredKeys() []interface{}
+
+	// RangeExpired calls fn for every expired item which has not been removed
+	// yet, until fn returns false, without removing them. It runs fn after
+	// releasing the lock, so fn may use the cache. Values which fail to
+	// deserialize are skipped.
+	RangeExpired(fn func(key, value interface{}) bool)
+
+	// Range calls fn for every item, or every live one if checkExpired is
+	// true, until fn returns false. Like RangeExpired, it runs fn after
+	// releasing the lock on a copy of the items, and skips the values which
+	// fail to deserialize. It leaves the eviction order untouched.
+	Range(checkExpired bool, fn func(key, value interface{}) bool)
+
 	//Existed checks if key exists in cache
 	Existed(key interface{}) bool
 
+	// GetRaw returns the value stored for key as is, i.e. in the form returned
+	// by serializeFunc, without running deserializeFunc. It neither updates
+	// the stats nor the eviction order, and never calls the loader.
+	GetRaw(key interface{}) (interface{}, bool)
+
+	// GetExpiration returns the time the item for key expires at unless it is
+	// read or set again, or the zero time if it never expires. It returns
+	// ErrKeyNotFound for a missing or expired key. Like GetRaw, it neither
+	// updates the stats nor the eviction order.
+	GetExpiration(key interface{}) (time.Time, error)
+
+	// GetMultiWithExpiration returns the value and expiration of every given key
+	// that is present and not expired. It neither updates the hit/miss stats nor
+	// the eviction order of the returned items.
+	GetMultiWithExpiration(keys []interface{}) map[interface{}]Entry
+
+	// Snapshot returns all live key-value pairs like GetALL(true), but only holds
+	// the lock while copying the stored values and runs deserializeFunc after
+	// releasing it. The result reflects the set of keys at the time of the copy.
+	Snapshot() map[interface{}]interface{}
+
+	// ExpireAll sets the expiration of every live item to now+expiration and
+	// returns the number of items updated. A zero expiration clears their
+	// expiration and a negative one expires them immediately. The eviction
+	// order of the items is left untouched.
+	ExpireAll(expiration time.Duration) int
+
+	// Touch sets the expiration of the live item for key to now+expiration
+	// without setting its value again, and reports whether the key was found.
+	// A zero expiration clears its expiration. It neither counts as a hit nor
+	// calls addedFunc, and leaves the eviction order untouched.
+	Touch(key interface{}, expiration time.Duration) bool
+
+	// SetWithPolicy sets a value with both a time to live, after which it
+	// expires, and a time to idle, after which it expires unless it has been
+	// read. A zero ttl or tti keeps the Expiration or MaxIdle of the builder,
+	// and a negative ttl removes the key like SetWithExpire.
+	SetWithPolicy(key, value interface{}, ttl, tti time.Duration) error
+
+	// Version returns the version of the live item for key. The version
+	// increases every time the key is set or reloaded.
+	Version(key interface{}) (uint64, bool)
+
+	// CompareVersionAndSwap sets value for key only if the version of key is
+	// still version, and reports whether it did. A zero version matches a
+	// missing or expired key.
+	CompareVersionAndSwap(key interface{}, version uint64, value interface{}) (bool, error)
+
+	// SetIfAbsent sets value for key like Set, but only if key is missing or
+	// expired, and reports whether it did. A present key is left untouched,
+	// including its position in the eviction order.
+	SetIfAbsent(key, value interface{}) (bool, error)
+
+	// GetOrSet returns the value of key with loaded true if key is present.
+	// Otherwise it sets value like Set and returns it with loaded false. The
+	// check and the set happen atomically. It counts as a hit or a miss.
+	GetOrSet(key, value interface{}) (actual interface{}, loaded bool, err error)
+
+	// CompareAndSwap sets new for key like Set, but only if the live value of
+	// key equals old as compared by the EqualFunc, and reports whether it did.
+	// The expiration of key is kept unless the cache has a default Expiration.
+	CompareAndSwap(key, old, new interface{}) (bool, error)
+
+	// Swap sets newValue for key like Set and returns the previous value, with
+	// existed false if key was missing or expired. In an LFU cache it keeps the
+	// frequency of key.
+	Swap(key, newValue interface{}) (old interface{}, existed bool, err error)
+
+	// Update sets for key the value returned by fn, which gets the current
+	// value of key, or exists false if key is missing or expired. Nothing is
+	// stored if fn returns an error, which Update returns. fn runs with the
+	// cache locked, so it must not use the cache.
+	Update(key interface{}, fn func(old interface{}, exists bool) (new interface{}, err error)) error
+
+	// Increment adds delta to the int, int64 or float64 value of key, keeping
+	// its type and expiration, and returns the result as an int64. A missing
+	// or expired key is set to delta as an int64. A float64 value must stay a
+	// whole number, so that the result is exact. It returns ErrNotANumber for
+	// other values, and ErrOverflow, leaving the value unchanged, for a result
+	// which does not fit in the type of the value or in an int64. It counts as
+	// a hit or a miss.
+	Increment(key interface{}, delta int64) (int64, error)
+
+	// Decrement subtracts delta from the value of key like Increment.
+	Decrement(key interface{}, delta int64) (int64, error)
+
+	// GetOrSetWithTTLFunc returns the value for key if it is present. Otherwise
+	// it calls fn and stores the returned value with the returned ttl, which
+	// follows the SetWithExpire semantics. Concurrent callers missing the same
+	// key share a single call of fn, and nothing is stored if fn fails. If fn
+	// panics, the panic is raised again in the caller which ran fn, and the
+	// callers sharing its call get it as an error. The stored value counts as
+	// an explicit set for ExplicitSetWinsOverLoad.
+	GetOrSetWithTTLFunc(key interface{}, fn func() (interface{}, time.Duration, error)) (interface{}, error)
+
+	// Type returns the eviction type of the cache, such as TypeLru.
+	Type() string
+
+	// RecentOps returns the operations recorded by AuditLog, oldest first.
+	RecentOps() []AuditEntry
+
+	// RecentlySet returns up to n of the distinct keys most recently set,
+	// most recent first, out of those tracked by TrackRecentlySet.
+	RecentlySet(n int) []interface{}
+
+	// Transaction runs fn with a Tx whose writes are buffered and applied
+	// together once fn returns nil, or discarded if it returns an error or a
+	// value fails to serialize. The cache is locked while fn runs, so fn must
+	// not use the cache itself.
+	Transaction(fn func(tx Tx) error) error
+
+	// PauseEviction stops Set from evicting items when the cache is full until
+	// ResumeEviction is called, e.g. so that a bulk load cannot evict the keys
+	// it just loaded. Pauses nest: eviction resumes once every PauseEviction
+	// call has been matched by a ResumeEviction call.
+	PauseEviction()
+
+	// ResumeEviction ends a pause started by PauseEviction. Ending the last
+	// pause evicts the items exceeding the size in the usual policy order.
+	ResumeEviction()
+
+	// Compact rebuilds the internal maps once the cache has shrunk far below
+	// its peak size, so the memory held by the old buckets can be reclaimed.
+	Compact()
+
 	set(key, value interface{}) (interface{}, error)
 	get(key interface{}, onLoad bool) (interface{}, error)
+	remove(key interface{}) bool
+	peek(key interface{}) *cacheItem
+	access(key interface{})
+	forEach(fn func(item *cacheItem) bool)
+	evictOverflow()
+
+	// LoadRatePerSecond returns the number of loader calls in the last second.
+	LoadRatePerSecond() float64
+
+	// EvictionChan returns the channel receiving the items which left the
+	// cache, or nil if the cache was not built with EvictionChanWithPolicy.
+	EvictionChan() <-chan EvictionEvent
+
+	// EvictionStats returns the stats of the items evicted to make room, if
+	// the cache was built with TrackEvictionStats.
+	EvictionStats() EvictionStatsSnapshot
+
+	// DroppedEvictions returns the number of eviction events discarded
+	// because the eviction channel was full.
+	DroppedEvictions() uint64
+
+	// ResetStats clears the hit, miss and evict counts and the eviction callback times,
+	// and restarts PeakLen at the current number of items.
+	ResetStats()
 
 	statsAccessor
 }
@@ -58,44 +320,177 @@ type LoadingCache interface {
 	Cache
 	// Get a value from cache pool using key if it exists. If not exists and it has LoaderFunc,
 	// it will generate the value using you have specified LoaderFunc method returns value.
+	// A nil ctx is replaced with the BaseContext, or context.Background, before it reaches the loader.
 	Get(ctx context.Context, key interface{}) (interface{}, error)
 
 	//Refresh refresh a new value using by specified key.
 	Refresh(ctx context.Context, key interface{}) (interface{}, error)
+
+	// GetWithLoader works like Get, but loads a missing value with loader instead
+	// of the configured loader. A nil loader falls back to the configured one.
+	GetWithLoader(ctx context.Context, key interface{}, loader LoaderExpireFunc) (interface{}, error)
+
+	// GetWithDefault works like Get, but returns defaultValue instead of an
+	// error if the key is missing or the loader fails.
+	GetWithDefault(ctx context.Context, key, defaultValue interface{}) interface{}
+
+	// GetMultiDetailed gets the values of keys like Get and reports for each
+	// returned key whether its value was loaded, i.e. missed the cache, as
+	// opposed to found in it. A key whose load is shared with a concurrent
+	// caller counts as loaded too. Keys the loader fails for are left out of
+	// values and reported in a *MultiError.
+	GetMultiDetailed(ctx context.Context, keys []interface{}) (values map[interface{}]interface{}, loaded map[interface{}]bool, err error)
+
+	// GetMulti gets the values of keys like GetMultiDetailed, without
+	// reporting which of them were loaded.
+	GetMulti(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error)
+
+	// GetWithLoadGroup gets the value of key like Get, but loads a missing key
+	// with loader, sharing the call with the concurrent misses of any key in
+	// the same groupKey. Each caller stores the shared result under its own key.
+	GetWithLoadGroup(ctx context.Context, key, groupKey interface{}, loader func(ctx context.Context) (interface{}, error)) (interface{}, error)
+
+	// SetLoader replaces the loader. Loads already running keep the previous
+	// one. A nil loader disables loading, so that missing keys are not found.
+	SetLoader(loader LoaderExpireFunc)
+
+	// ForceRefresh loads a new value for key even if it is cached, stores it and
+	// returns it together with the value cached before, if any. Concurrent loads
+	// of the key are shared like in Get.
+	ForceRefresh(ctx context.Context, key interface{}) (value interface{}, old interface{}, existed bool, err error)
+}
+
+// Logger receives the warnings of a cache. *log.Logger satisfies it.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// KeyCodec converts between the keys used by callers and the keys stored in
+// the cache, e.g. to store a compact hash of a large key. Decode must reverse
+// Encode, since stored keys are decoded before they are handed back to callers.
+type KeyCodec interface {
+	Encode(key interface{}) interface{}
+	Decode(key interface{}) interface{}
 }
 
 type (
 	LoaderFunc       func(context.Context, interface{}) (interface{}, error)
 	LoaderExpireFunc func(context.Context, interface{}) (interface{}, *time.Duration, error)
+	// EvictedFunc, ExpiredFunc, PurgeVisitorFunc and AddedFunc are called
+	// after the cache lock is released, so they may call back into the cache.
+	// They run in the goroutine which made the change, before its call
+	// returns, and in the order of the changes it made. Calls caused by
+	// different goroutines may interleave, and the cache may have changed
+	// again by the time a callback runs.
 	EvictedFunc      func(interface{}, interface{})
+	ExpiredFunc      func(interface{}, interface{})
 	PurgeVisitorFunc func(interface{}, interface{})
 	AddedFunc        func(interface{}, interface{})
 	DeserializeFunc  func(interface{}, interface{}) (interface{}, error)
 	SerializeFunc    func(interface{}, interface{}) (interface{}, error)
+	EqualFunc        func(interface{}, interface{}) bool
 )
 
 type CacheBuilder struct {
-	clock            clock
+	clock            Clock
 	tp               string
 	size             int
 	loaderExpireFunc LoaderExpireFunc
 	evictedFunc      EvictedFunc
+	expiredFunc      ExpiredFunc
 	purgeVisitorFunc PurgeVisitorFunc
 	addedFunc        AddedFunc
 	expiration       *time.Duration
+	maxIdle          time.Duration
 	deserializeFunc  DeserializeFunc
 	serializeFunc    SerializeFunc
+	copyOnExport     func(interface{}) interface{}
+
+	lazyExpireDisabled bool
+	lazySerialize      bool
+	arcGhostLimit      int
+	arcMaxTracked      int
+
+	lfuBatchedIncrement bool
+	recycleNodes        bool
+
+	countExistedInStats bool
+	auditLogSize        int
+	recentlySetSize     int
+	softValues          bool
+	keyCodec            KeyCodec
+	loaderErrorTTL      time.Duration
+	equalFunc           EqualFunc
+	skipUnchangedSet    bool
+	dropLoadIfRemoved   bool
+	explicitSetWins     bool
+	evictExpiredFirst   bool
+	setCountsAsAccess   *bool
+	monotonicExpiry     bool
+	baseCtx             context.Context
+	logger              Logger
+	slowCallback        time.Duration
+	maxLoadRate         float64
+	onLoadRateExceeded  func()
+	keyType             reflect.Type
+	hitRateMode         HitRateMode
+	warnOnZeroHits      int
+	evictionStatsReads  int
+	evictionChanBuffer  int
+	evictionChanPolicy  OverflowPolicy
+	evictionChan        bool
 }
 
 func New(size int) *CacheBuilder {
 	return &CacheBuilder{
-		clock: newRealClock(),
-		tp:    TypeSimple,
-		size:  size,
+		clock:             newRealClock(),
+		tp:                TypeSimple,
+		size:              size,
+		explicitSetWins:   true,
+		evictExpiredFirst: true,
+	}
+}
+
+// MustNewLRU builds an LRU cache of the given size with the default settings.
+// It panics if the size is invalid.
+func MustNewLRU(size int) Cache {
+	return New(size).LRU().Build()
+}
+
+// MustNewLFU builds an LFU cache like MustNewLRU.
+func MustNewLFU(size int) Cache {
+	return New(size).LFU().Build()
+}
+
+// MustNewARC builds an ARC cache like MustNewLRU.
+func MustNewARC(size int) Cache {
+	return New(size).ARC().Build()
+}
+
+// MustNewLoadingLRU builds an LRU cache of the given size which loads missing
+// values with loader. It panics if the size is invalid or loader is nil.
+func MustNewLoadingLRU(size int, loader LoaderFunc) LoadingCache {
+	return New(size).LRU().LoaderFunc(mustLoader(loader)).Build()
+}
+
+func mustLoader(loader LoaderFunc) LoaderFunc {
+	if loader == nil {
+		panic("loader func required")
 	}
+	return loader
+}
+
+// MustNewLoadingLFU builds an LFU cache like MustNewLoadingLRU.
+func MustNewLoadingLFU(size int, loader LoaderFunc) LoadingCache {
+	return New(size).LFU().LoaderFunc(mustLoader(loader)).Build()
+}
+
+// MustNewLoadingARC builds an ARC cache like MustNewLoadingLRU.
+func MustNewLoadingARC(size int, loader LoaderFunc) LoadingCache {
+	return New(size).ARC().LoaderFunc(mustLoader(loader)).Build()
 }
 
-func (cb *CacheBuilder) Clock(clock clock) *CacheBuilder {
+func (cb *CacheBuilder) Clock(clock Clock) *CacheBuilder {
 	cb.clock = clock
 	return cb
 }
@@ -144,6 +539,13 @@ func (cb *CacheBuilder) EvictedFunc(evictedFunc EvictedFunc) *CacheBuilder {
 	return cb
 }
 
+// ExpiredFunc sets a callback called, after evictedFunc, for the items which
+// are removed because they expired, but not for evictions or explicit removals.
+func (cb *CacheBuilder) ExpiredFunc(expiredFunc ExpiredFunc) *CacheBuilder {
+	cb.expiredFunc = expiredFunc
+	return cb
+}
+
 func (cb *CacheBuilder) PurgeVisitorFunc(purgeVisitorFunc PurgeVisitorFunc) *CacheBuilder {
 	cb.purgeVisitorFunc = purgeVisitorFunc
 	return cb
@@ -164,36 +566,302 @@ func (cb *CacheBuilder) SerializeFunc(serializeFunc SerializeFunc) *CacheBuilder
 	return cb
 }
 
+// CopyOnExport sets a function which copies the values returned by GetALL and
+// Snapshot, so that callers can mutate them without changing the cached ones.
+// It is applied after deserializeFunc.
+func (cb *CacheBuilder) CopyOnExport(copyFunc func(interface{}) interface{}) *CacheBuilder {
+	cb.copyOnExport = copyFunc
+	return cb
+}
+
 func (cb *CacheBuilder) Expiration(expiration time.Duration) *CacheBuilder {
 	cb.expiration = &expiration
 	return cb
 }
 
+// MaxIdle expires items which have not been read for maxIdle.
+func (cb *CacheBuilder) MaxIdle(maxIdle time.Duration) *CacheBuilder {
+	cb.maxIdle = maxIdle
+	return cb
+}
+
+// LazySerialize stores values in their native form and only runs serializeFunc
+// when a value leaves the cache through evictedFunc or purgeVisitorFunc, which
+// still receive the serialized value. deserializeFunc is skipped on reads, so
+// callers share the stored value instead of getting a decoded copy.
+func (cb *CacheBuilder) LazySerialize(lazy bool) *CacheBuilder {
+	cb.lazySerialize = lazy
+	return cb
+}
+
+// ARCGhostLimit caps the combined length of the ARC ghost lists, which keep the
+// keys of recently evicted items. The oldest ghost entries are dropped once the
+// cap is exceeded. Zero, the default, leaves them bounded by the cache size only.
+func (cb *CacheBuilder) ARCGhostLimit(n int) *CacheBuilder {
+	cb.arcGhostLimit = n
+	return cb
+}
+
+// CountExistedInStats makes Existed count as a hit or a miss in the stats.
+func (cb *CacheBuilder) CountExistedInStats(count bool) *CacheBuilder {
+	cb.countExistedInStats = count
+	return cb
+}
+
+// AuditLog keeps a log of the last size operations (gets, sets, removes and
+// evictions), which can be read with RecentOps.
+func (cb *CacheBuilder) AuditLog(size int) *CacheBuilder {
+	cb.auditLogSize = size
+	return cb
+}
+
+// TrackRecentlySet remembers the last size distinct keys that were set,
+// which can be read with RecentlySet.
+func (cb *CacheBuilder) TrackRecentlySet(size int) *CacheBuilder {
+	cb.recentlySetSize = size
+	return cb
+}
+
+// SoftValues holds pointer values weakly, so the garbage collector can reclaim
+// a value once nothing outside the cache references it. A reclaimed value is
+// treated like an expired item, so a loading cache loads it again on the next
+// Get. Non-pointer values and small pointer-free objects are held as usual.
+// It requires Go 1.24 or later and has no effect with older toolchains.
+func (cb *CacheBuilder) SoftValues(soft bool) *CacheBuilder {
+	cb.softValues = soft
+	return cb
+}
+
+// KeyCodec sets the codec converting caller keys into stored keys.
+// Callbacks, loaders and Keys/GetALL always see the decoded keys.
+func (cb *CacheBuilder) KeyCodec(codec KeyCodec) *CacheBuilder {
+	cb.keyCodec = codec
+	return cb
+}
+
+// CacheLoaderErrors caches errors returned by the loader for ttl. Loads of the
+// key within that window return the same error without calling the loader,
+// just like the callers which were waiting on the failed load, so transient
+// failures are retried at most once per window. Purge forgets them.
+func (cb *CacheBuilder) CacheLoaderErrors(ttl time.Duration) *CacheBuilder {
+	cb.loaderErrorTTL = ttl
+	return cb
+}
+
+// ARCMaxTracked caps the number of keys tracked by an ARC cache. Besides the
+// cached items, ARC remembers the keys of up to size recently evicted items in
+// its ghost lists, so it can track up to 2*size keys. Ghost entries are dropped
+// to keep the total within n. Zero, the default, leaves it uncapped.
+func (cb *CacheBuilder) ARCMaxTracked(n int) *CacheBuilder {
+	cb.arcMaxTracked = n
+	return cb
+}
+
+// EqualFunc sets the function comparing cached values, such as in SkipUnchangedSet
+// and CompareAndSwap.
+// Values are compared with reflect.DeepEqual by default.
+func (cb *CacheBuilder) EqualFunc(equalFunc EqualFunc) *CacheBuilder {
+	cb.equalFunc = equalFunc
+	return cb
+}
+
+// SkipUnchangedSet turns a Set storing a value equal to the live cached one
+// into a no-op: addedFunc is not called, the value is not serialized and
+// neither the eviction order nor the expiration of the item change.
+func (cb *CacheBuilder) SkipUnchangedSet(skip bool) *CacheBuilder {
+	cb.skipUnchangedSet = skip
+	return cb
+}
+
+// DropLoadIfRemoved discards the value of a load if its key was removed while
+// the loader was running. The loaded value is still returned to the callers
+// waiting for it, but it is not stored, so the removed key stays absent.
+// Tracking the removals takes the write lock once more for every load.
+func (cb *CacheBuilder) DropLoadIfRemoved(drop bool) *CacheBuilder {
+	cb.dropLoadIfRemoved = drop
+	return cb
+}
+
+// ExplicitSetWinsOverLoad discards the value of a load if its key was set
+// while the loader was running, so the explicitly set value is kept. The loaded
+// value is still returned to the callers waiting for it. It is enabled by default.
+// Tracking the sets takes the write lock once more for every load, so disable
+// it if loads are frequent and racing sets are not a concern.
+func (cb *CacheBuilder) ExplicitSetWinsOverLoad(wins bool) *CacheBuilder {
+	cb.explicitSetWins = wins
+	return cb
+}
+
+// LFUBatchedIncrement makes an LFU cache serve read hits under the read lock
+// and buffer their frequency increments, which are applied in batches under
+// the write lock. Frequencies lag behind the reads until a batch is applied,
+// trading exact eviction order for read throughput. Items with a MaxIdle are
+// still read under the write lock.
+func (cb *CacheBuilder) LFUBatchedIncrement(batched bool) *CacheBuilder {
+	cb.lfuBatchedIncrement = batched
+	return cb
+}
+
+// SimpleInsertionOrder used to make a simple cache evict its items in the
+// order they were inserted instead of in map order. It has no effect.
+//
+// Deprecated: a simple cache always evicts in insertion order.
+func (cb *CacheBuilder) SimpleInsertionOrder(ordered bool) *CacheBuilder {
+	return cb
+}
+
+// RecycleNodes makes an LRU cache reuse the list node and item of the entry it
+// evicts to make room for a new key, instead of allocating new ones, which
+// reduces the garbage produced under a high insert/evict churn. The entry is
+// an expired one first if EvictExpiredFirst is set, like for any eviction.
+// Only this path recycles: the nodes of the items removed by Remove, expired
+// on a read or dropped by Purge are left to the garbage collector. There is
+// no pool of spare nodes, because container/list cannot insert a node again
+// once it has been removed from the list.
+func (cb *CacheBuilder) RecycleNodes(recycle bool) *CacheBuilder {
+	cb.recycleNodes = recycle
+	return cb
+}
+
+// BaseContext sets the parent context of the loads the cache starts by itself,
+// such as the background refresh of GetIFPresent. The values of ctx are also
+// visible to loaders called through Get and the like, whose deadline and
+// cancellation still come from the context of the call.
+func (cb *CacheBuilder) BaseContext(ctx context.Context) *CacheBuilder {
+	cb.baseCtx = ctx
+	return cb
+}
+
+// MonotonicExpiry makes the cache derive the current time from a monotonic
+// reading taken when it was built, so that expirations follow the elapsed
+// time even if the wall clock is stepped. A custom Clock takes part by also
+// implementing Monotonic() time.Duration; otherwise its Now is used as is.
+func (cb *CacheBuilder) MonotonicExpiry(monotonic bool) *CacheBuilder {
+	cb.monotonicExpiry = monotonic
+	return cb
+}
+
+// SetCountsAsAccess makes setting an existing key count as an access to it,
+// like a read hit: it moves the key to the front of an LRU cache, increments
+// its frequency in an LFU cache and promotes it in an ARC cache. Otherwise
+// the eviction order is left unchanged. By default it is enabled for LRU
+// caches only, as setting a key has always promoted it there but not in LFU
+// and ARC caches.
+func (cb *CacheBuilder) SetCountsAsAccess(access bool) *CacheBuilder {
+	cb.setCountsAsAccess = &access
+	return cb
+}
+
+// EvictExpiredFirst makes a full cache evict an expired item, if it finds one
+// among the next few eviction candidates, instead of the policy's live victim.
+// It is enabled by default.
+func (cb *CacheBuilder) EvictExpiredFirst(first bool) *CacheBuilder {
+	cb.evictExpiredFirst = first
+	return cb
+}
+
+// Logger sets the logger receiving the warnings of the cache.
+func (cb *CacheBuilder) Logger(logger Logger) *CacheBuilder {
+	cb.logger = logger
+	return cb
+}
+
+// SlowCallbackThreshold logs a warning whenever a single evictedFunc,
+// expiredFunc or purgeVisitorFunc call takes longer than d. It requires a Logger.
+func (cb *CacheBuilder) SlowCallbackThreshold(d time.Duration) *CacheBuilder {
+	cb.slowCallback = d
+	return cb
+}
+
+// MaxLoadRate calls onExceed whenever the number of loader calls in the last
+// second rises above rps, e.g. to detect many unique keys bypassing the cache.
+// It only observes the load rate and does not throttle the loader.
+func (cb *CacheBuilder) MaxLoadRate(rps float64, onExceed func()) *CacheBuilder {
+	cb.maxLoadRate = rps
+	cb.onLoadRateExceeded = onExceed
+	return cb
+}
+
+// HitRateMode sets how HitRate is computed. It defaults to ModeCumulative.
+func (cb *CacheBuilder) HitRateMode(mode HitRateMode) *CacheBuilder {
+	cb.hitRateMode = mode
+	return cb
+}
+
+// TrackEvictionStats makes the cache track the age of the items it evicts to
+// make room and whether they were read within the last recentReads reads,
+// as reported by EvictionStats.
+func (cb *CacheBuilder) TrackEvictionStats(recentReads int) *CacheBuilder {
+	cb.evictionStatsReads = recentReads
+	return cb
+}
+
+// WarnOnZeroHits makes the cache warn through the Logger, once, if none of
+// its first after lookups is a hit. This usually means the keys are read in
+// a different form than they are written, or the expiration is too short.
+func (cb *CacheBuilder) WarnOnZeroHits(after int) *CacheBuilder {
+	cb.warnOnZeroHits = after
+	return cb
+}
+
+// EvictionChanWithPolicy makes the cache send the items leaving it on
+// EvictionChan, a channel with the given buffer. policy decides what happens
+// to an event when the buffer is full; see OverflowPolicy.
+func (cb *CacheBuilder) EvictionChanWithPolicy(buffer int, policy OverflowPolicy) *CacheBuilder {
+	cb.evictionChan = true
+	cb.evictionChanBuffer = buffer
+	cb.evictionChanPolicy = policy
+	return cb
+}
+
+// EnforceKeyType makes the cache reject keys whose concrete type differs from
+// the one of sample with ErrKeyTypeMismatch, e.g. an int key in a cache of
+// string keys, instead of silently missing.
+func (cb *CacheBuilder) EnforceKeyType(sample interface{}) *CacheBuilder {
+	cb.keyType = reflect.TypeOf(sample)
+	return cb
+}
+
+// LazyExpireDisabled keeps expired items in the cache when they are read.
+// Reads still return ErrKeyNotFound for them, but they stay visible to
+// Keys(false), GetALL(false) and Len(false) until they are overwritten or removed.
+func (cb *CacheBuilder) LazyExpireDisabled(disabled bool) *CacheBuilder {
+	cb.lazyExpireDisabled = disabled
+	return cb
+}
+
 func (cb *CacheBuilder) Build() Cache {
-	if cb.size <= 0 && cb.tp != TypeSimple {
-		panic("gcache: Cache size <= 0")
+	if err := cb.validate(); err != nil {
+		panic(err)
 	}
 
 	return cb.build()
 }
 
+func (cb *CacheBuilder) validate() error {
+	if cb.size <= 0 && cb.tp != TypeSimple {
+		return errors.New("gcache: Cache size <= 0")
+	}
+	if cb.evictionChan && cb.evictionChanBuffer < 0 {
+		return errors.New("gcache: EvictionChan buffer < 0")
+	}
+	return nil
+}
+
 func (cb *CacheBuilder) build() LoadingCache {
-	switch cb.tp {
-	case TypeSimple:
-		return newSimpleCache(cb)
-	case TypeLru:
-		return newLRUCache(cb)
-	case TypeLfu:
-		return newLFUCache(cb)
-	case TypeArc:
-		return newARC(cb)
-	default:
+	factory, ok := lookupEvictType(cb.tp)
+	if !ok {
 		panic("gcache: Unknown type " + cb.tp)
 	}
+	return factory(cb)
 }
 
 type loadingCacheBuilder struct {
 	*CacheBuilder
+
+	warmCtx         context.Context
+	warmKeys        []interface{}
+	warmConcurrency int
 }
 
 func (cb *loadingCacheBuilder) EvictType(tp string) *loadingCacheBuilder {
@@ -222,6 +890,11 @@ func (cb *loadingCacheBuilder) EvictedFunc(evictedFunc EvictedFunc) *loadingCach
 	return cb
 }
 
+func (cb *loadingCacheBuilder) ExpiredFunc(expiredFunc ExpiredFunc) *loadingCacheBuilder {
+	cb.expiredFunc = expiredFunc
+	return cb
+}
+
 func (cb *loadingCacheBuilder) PurgeVisitorFunc(purgeVisitorFunc PurgeVisitorFunc) *loadingCacheBuilder {
 	cb.purgeVisitorFunc = purgeVisitorFunc
 	return cb
@@ -242,152 +915,2036 @@ func (cb *loadingCacheBuilder) SerializeFunc(serializeFunc SerializeFunc) *loadi
 	return cb
 }
 
+func (cb *loadingCacheBuilder) CopyOnExport(copyFunc func(interface{}) interface{}) *loadingCacheBuilder {
+	cb.copyOnExport = copyFunc
+	return cb
+}
+
 func (cb *loadingCacheBuilder) Expiration(expiration time.Duration) *loadingCacheBuilder {
 	cb.expiration = &expiration
 	return cb
 }
 
-func (cb *loadingCacheBuilder) Build() LoadingCache {
-	if cb.loaderExpireFunc == nil {
-		panic("loader func required")
-	}
-	return cb.CacheBuilder.Build().(LoadingCache)
+func (cb *loadingCacheBuilder) MaxIdle(maxIdle time.Duration) *loadingCacheBuilder {
+	cb.maxIdle = maxIdle
+	return cb
 }
 
-func buildCache(b *baseCache, c Cache, cb *CacheBuilder) {
-	b.cache = c
+func (cb *loadingCacheBuilder) LazySerialize(lazy bool) *loadingCacheBuilder {
+	cb.lazySerialize = lazy
+	return cb
+}
 
-	b.clock = cb.clock
-	b.size = cb.size
-	b.loaderExpireFunc = cb.loaderExpireFunc
-	b.expiration = cb.expiration
-	b.addedFunc = cb.addedFunc
-	b.deserializeFunc = cb.deserializeFunc
-	b.serializeFunc = cb.serializeFunc
-	b.evictedFunc = cb.evictedFunc
-	b.purgeVisitorFunc = cb.purgeVisitorFunc
-	b.stats = &stats{}
+func (cb *loadingCacheBuilder) ARCGhostLimit(n int) *loadingCacheBuilder {
+	cb.arcGhostLimit = n
+	return cb
 }
 
-type cacheItem struct {
-	clock      clock
-	key        interface{}
-	value      interface{}
-	expiration *time.Time
+func (cb *loadingCacheBuilder) CountExistedInStats(count bool) *loadingCacheBuilder {
+	cb.countExistedInStats = count
+	return cb
 }
 
-// IsExpired returns boolean value whether this item is expired or not.
-func (item *cacheItem) IsExpired(now *time.Time) bool {
-	if item.expiration == nil {
-		return false
-	}
-	if now == nil {
-		t := item.clock.Now()
-		now = &t
-	}
-	return item.expiration.Before(*now)
+func (cb *loadingCacheBuilder) AuditLog(size int) *loadingCacheBuilder {
+	cb.auditLogSize = size
+	return cb
 }
 
-type baseCache struct {
-	cache Cache
+func (cb *loadingCacheBuilder) TrackRecentlySet(size int) *loadingCacheBuilder {
+	cb.recentlySetSize = size
+	return cb
+}
 
-	clock            clock
-	size             int
-	loaderExpireFunc LoaderExpireFunc
-	evictedFunc      EvictedFunc
-	purgeVisitorFunc PurgeVisitorFunc
-	addedFunc        AddedFunc
-	deserializeFunc  DeserializeFunc
-	serializeFunc    SerializeFunc
-	expiration       *time.Duration
-	mu               sync.RWMutex
-	loadGroup        Group
-	*stats
+func (cb *loadingCacheBuilder) SoftValues(soft bool) *loadingCacheBuilder {
+	cb.softValues = soft
+	return cb
 }
 
-func (c *baseCache) Set(key, value interface{}) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_, err := c.cache.set(key, value)
-	return err
+func (cb *loadingCacheBuilder) KeyCodec(codec KeyCodec) *loadingCacheBuilder {
+	cb.keyCodec = codec
+	return cb
 }
 
-func (c *baseCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	item, err := c.cache.set(key, value)
-	if err != nil {
-		return err
-	}
+func (cb *loadingCacheBuilder) CacheLoaderErrors(ttl time.Duration) *loadingCacheBuilder {
+	cb.loaderErrorTTL = ttl
+	return cb
+}
 
-	t := c.clock.Now().Add(expiration)
-	item.(*cacheItem).expiration = &t
-	return nil
+func (cb *loadingCacheBuilder) ARCMaxTracked(n int) *loadingCacheBuilder {
+	cb.arcMaxTracked = n
+	return cb
 }
 
-// Get a value from cache pool using key if it exists. If not exists and it has LoaderFunc, it will generate the value using you have specified LoaderFunc method returns value.
-func (c *baseCache) Get(ctx context.Context, key interface{}) (interface{}, error) {
-	v, err := c.cache.get(key, false)
+func (cb *loadingCacheBuilder) EqualFunc(equalFunc EqualFunc) *loadingCacheBuilder {
+	cb.equalFunc = equalFunc
+	return cb
+}
+
+func (cb *loadingCacheBuilder) SkipUnchangedSet(skip bool) *loadingCacheBuilder {
+	cb.skipUnchangedSet = skip
+	return cb
+}
+
+func (cb *loadingCacheBuilder) DropLoadIfRemoved(drop bool) *loadingCacheBuilder {
+	cb.dropLoadIfRemoved = drop
+	return cb
+}
+
+func (cb *loadingCacheBuilder) ExplicitSetWinsOverLoad(wins bool) *loadingCacheBuilder {
+	cb.explicitSetWins = wins
+	return cb
+}
+
+func (cb *loadingCacheBuilder) LFUBatchedIncrement(batched bool) *loadingCacheBuilder {
+	cb.lfuBatchedIncrement = batched
+	return cb
+}
+
+// Deprecated: a simple cache always evicts in insertion order.
+func (cb *loadingCacheBuilder) SimpleInsertionOrder(ordered bool) *loadingCacheBuilder {
+	return cb
+}
+
+func (cb *loadingCacheBuilder) RecycleNodes(recycle bool) *loadingCacheBuilder {
+	cb.recycleNodes = recycle
+	return cb
+}
+
+func (cb *loadingCacheBuilder) BaseContext(ctx context.Context) *loadingCacheBuilder {
+	cb.baseCtx = ctx
+	return cb
+}
+
+func (cb *loadingCacheBuilder) MonotonicExpiry(monotonic bool) *loadingCacheBuilder {
+	cb.monotonicExpiry = monotonic
+	return cb
+}
+
+func (cb *loadingCacheBuilder) SetCountsAsAccess(access bool) *loadingCacheBuilder {
+	cb.setCountsAsAccess = &access
+	return cb
+}
+
+func (cb *loadingCacheBuilder) EvictExpiredFirst(first bool) *loadingCacheBuilder {
+	cb.evictExpiredFirst = first
+	return cb
+}
+
+func (cb *loadingCacheBuilder) Logger(logger Logger) *loadingCacheBuilder {
+	cb.logger = logger
+	return cb
+}
+
+func (cb *loadingCacheBuilder) SlowCallbackThreshold(d time.Duration) *loadingCacheBuilder {
+	cb.slowCallback = d
+	return cb
+}
+
+func (cb *loadingCacheBuilder) MaxLoadRate(rps float64, onExceed func()) *loadingCacheBuilder {
+	cb.maxLoadRate = rps
+	cb.onLoadRateExceeded = onExceed
+	return cb
+}
+
+func (cb *loadingCacheBuilder) HitRateMode(mode HitRateMode) *loadingCacheBuilder {
+	cb.hitRateMode = mode
+	return cb
+}
+
+func (cb *loadingCacheBuilder) TrackEvictionStats(recentReads int) *loadingCacheBuilder {
+	cb.evictionStatsReads = recentReads
+	return cb
+}
+
+func (cb *loadingCacheBuilder) WarnOnZeroHits(after int) *loadingCacheBuilder {
+	cb.warnOnZeroHits = after
+	return cb
+}
+
+func (cb *loadingCacheBuilder) EvictionChanWithPolicy(buffer int, policy OverflowPolicy) *loadingCacheBuilder {
+	cb.evictionChan = true
+	cb.evictionChanBuffer = buffer
+	cb.evictionChanPolicy = policy
+	return cb
+}
+
+func (cb *loadingCacheBuilder) EnforceKeyType(sample interface{}) *loadingCacheBuilder {
+	cb.keyType = reflect.TypeOf(sample)
+	return cb
+}
+
+func (cb *loadingCacheBuilder) LazyExpireDisabled(disabled bool) *loadingCacheBuilder {
+	cb.lazyExpireDisabled = disabled
+	return cb
+}
+
+// WarmKeys makes Build load keys into the cache, with up to concurrency loads
+// at a time, before returning it.
+func (cb *loadingCacheBuilder) WarmKeys(ctx context.Context, keys []interface{}, concurrency int) *loadingCacheBuilder {
+	cb.warmCtx = ctx
+	cb.warmKeys = keys
+	cb.warmConcurrency = concurrency
+	return cb
+}
+
+func (cb *loadingCacheBuilder) Build() LoadingCache {
+	c, err := cb.BuildE()
+	if err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// BuildE works like Build, but returns an error instead of panicking,
+// including when loading one of the WarmKeys fails.
+func (cb *loadingCacheBuilder) BuildE() (LoadingCache, error) {
+	if cb.loaderExpireFunc == nil {
+		return nil, errors.New("loader func required")
+	}
+	if err := cb.validate(); err != nil {
+		return nil, err
+	}
+	c := cb.build()
+	if err := warm(cb.warmCtx, c, cb.warmKeys, cb.warmConcurrency); err != nil {
+		return nil, fmt.Errorf("gcache: warming the cache: %w", err)
+	}
+	return c, nil
+}
+
+// warm loads keys into c with up to concurrency loads at a time.
+// It returns a *MultiError holding the keys which failed to load.
+func warm(ctx context.Context, c LoadingCache, keys []interface{}, concurrency int) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs MultiError
+	)
+	ch := make(chan interface{})
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range ch {
+				if _, err := c.Get(ctx, key); err != nil {
+					mu.Lock()
+					errs.add(key, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, key := range keys {
+		ch <- key
+	}
+	close(ch)
+	wg.Wait()
+	return errs.errOrNil()
+}
+
+func buildCache(b *baseCache, c Cache, cb *CacheBuilder) {
+	b.cache = c
+
+	b.tp = cb.tp
+	b.clock = cb.clock
+	if cb.monotonicExpiry {
+		b.clock = newMonotonicClock(cb.clock)
+	}
+	b.size = cb.size
+	b.loader.Store(cb.loaderExpireFunc)
+	b.expiration = cb.expiration
+	b.maxIdle = cb.maxIdle
+	b.addedFunc = cb.addedFunc
+	b.deserializeFunc = cb.deserializeFunc
+	b.serializeFunc = cb.serializeFunc
+	b.copyOnExport = cb.copyOnExport
+	b.evictedFunc = cb.evictedFunc
+	b.expiredFunc = cb.expiredFunc
+	b.purgeVisitorFunc = cb.purgeVisitorFunc
+	b.lazyExpireDisabled = cb.lazyExpireDisabled
+	b.lazySerialize = cb.lazySerialize
+	b.countExistedInStats = cb.countExistedInStats
+	b.softValues = cb.softValues
+	b.keyCodec = cb.keyCodec
+	b.loaderErrorTTL = cb.loaderErrorTTL
+	b.equalFunc = cb.equalFunc
+	b.skipUnchangedSet = cb.skipUnchangedSet
+	b.dropLoadIfRemoved = cb.dropLoadIfRemoved
+	b.explicitSetWins = cb.explicitSetWins
+	b.evictExpiredFirst = cb.evictExpiredFirst
+	if cb.setCountsAsAccess != nil {
+		b.setCountsAsAccess = *cb.setCountsAsAccess
+	} else {
+		// Only the built-in LRU cache promotes its items on a set by default.
+		_, b.setCountsAsAccess = c.(*lruCache)
+	}
+	b.baseCtx = cb.baseCtx
+	b.logger = cb.logger
+	b.slowCallback = cb.slowCallback
+	b.maxLoadRate = cb.maxLoadRate
+	b.onLoadRateExceeded = cb.onLoadRateExceeded
+	b.keyType = cb.keyType
+	if cb.warnOnZeroHits > 0 {
+		b.warnOnZeroHits = uint64(cb.warnOnZeroHits)
+	}
+	if cb.auditLogSize > 0 {
+		b.auditLog = newAuditLog(cb.auditLogSize)
+	}
+	if cb.recentlySetSize > 0 {
+		b.recentlySet = newRecentKeys(cb.recentlySetSize)
+	}
+	if cb.evictionStatsReads > 0 {
+		b.evictionStats = &evictionStats{recentReads: uint64(cb.evictionStatsReads)}
+	}
+	if cb.evictionChan {
+		b.evictionChan = newEvictionChan(cb.evictionChanBuffer, cb.evictionChanPolicy)
+	}
+	b.stats = &stats{}
+	if cb.hitRateMode.halfLife > 0 {
+		b.stats.decay = &decayedHitRate{clock: b.clock, halfLife: cb.hitRateMode.halfLife}
+	}
+}
+
+// Entry is a snapshot of a cached value together with its expiration.
+type Entry struct {
+	Value interface{}
+	// ExpireAt is when the entry expires, by its expiration or by idling,
+	// whichever comes first. It is the zero time if the entry never expires.
+	ExpireAt time.Time
+	// Version is the version of the entry, see Cache.Version.
+	Version uint64
+}
+
+type cacheItem struct {
+	clock      Clock
+	key        interface{}
+	value      interface{}
+	expiration *time.Time
+
+	maxIdle        time.Duration
+	idleExpiration *time.Time
+
+	version uint64
+	// seq orders the items by the time they were inserted. It is kept when
+	// the item is set again.
+	seq uint64
+
+	// insertedAt and lastRead are only tracked for TrackEvictionStats.
+	insertedAt time.Time
+	lastRead   uint64
+}
+
+// setMaxIdle sets the max idle time of the item and starts its idle period.
+func (item *cacheItem) setMaxIdle(maxIdle time.Duration) {
+	item.maxIdle = maxIdle
+	item.touch()
+}
+
+// touch restarts the idle period of the item.
+func (item *cacheItem) touch() {
+	if item.maxIdle <= 0 {
+		item.idleExpiration = nil
+		return
+	}
+	t := item.clock.Now().Add(item.maxIdle)
+	item.idleExpiration = &t
+}
+
+// expireAt returns the earlier of the expiration and the idle expiration of
+// the item, or the zero time if it has neither.
+func (item *cacheItem) expireAt() time.Time {
+	var expireAt time.Time
+	if item.expiration != nil {
+		expireAt = *item.expiration
+	}
+	if idle := item.idleExpiration; idle != nil && (expireAt.IsZero() || idle.Before(expireAt)) {
+		expireAt = *idle
+	}
+	return expireAt
+}
+
+// IsExpired returns boolean value whether this item is expired or not.
+// An item whose soft value has been reclaimed counts as expired.
+func (item *cacheItem) IsExpired(now *time.Time) bool {
+	_, ok := item.liveValue(now)
+	return !ok
+}
+
+// liveValue returns the value of the item, or false if the item is expired
+// or its soft value has been reclaimed.
+func (item *cacheItem) liveValue(now *time.Time) (interface{}, bool) {
+	if item.expiration != nil || item.idleExpiration != nil {
+		if now == nil {
+			t := item.clock.Now()
+			now = &t
+		}
+		if item.expiration != nil && item.expiration.Before(*now) {
+			return nil, false
+		}
+		if item.idleExpiration != nil && item.idleExpiration.Before(*now) {
+			return nil, false
+		}
+	}
+	if sv, ok := item.value.(*softValue); ok {
+		return sv.value()
+	}
+	return item.value, true
+}
+
+// hardValue returns the value held by a soft value, or v itself otherwise.
+func hardValue(v interface{}) interface{} {
+	if sv, ok := v.(*softValue); ok {
+		v, _ = sv.value()
+	}
+	return v
+}
+
+type baseCache struct {
+	cache Cache
+
+	tp               string
+	clock            Clock
+	size             int
+	loader           atomic.Value // LoaderExpireFunc
+	evictedFunc      EvictedFunc
+	expiredFunc      ExpiredFunc
+	purgeVisitorFunc PurgeVisitorFunc
+	addedFunc        AddedFunc
+	deserializeFunc  DeserializeFunc
+	serializeFunc    SerializeFunc
+	copyOnExport     func(interface{}) interface{}
+	expiration       *time.Duration
+	maxIdle          time.Duration
+	mu               sync.RWMutex
+	loadGroup        Group
+	peakItems        int
+
+	lazyExpireDisabled bool
+	lazySerialize      bool
+
+	countExistedInStats bool
+	auditLog            *auditLog
+	recentlySet         *recentKeys
+	softValues          bool
+	keyCodec            KeyCodec
+	loaderErrorTTL      time.Duration
+	loaderErrorsMu      sync.Mutex
+	loaderErrors        map[interface{}]loaderError
+	equalFunc           EqualFunc
+	skipUnchangedSet    bool
+	dropLoadIfRemoved   bool
+	explicitSetWins     bool
+	evictExpiredFirst   bool
+	setCountsAsAccess   bool
+	baseCtx             context.Context
+	logger              Logger
+	slowCallback        time.Duration
+	loadRate            loadRate
+	maxLoadRate         float64
+	onLoadRateExceeded  func()
+	keyType             reflect.Type
+	evictionChan        *evictionChan
+	evictionStats       *evictionStats
+	warnOnZeroHits      uint64
+	zeroHitsWarned      uint32
+	// loadsInFlight records, for each key being loaded, how it was changed
+	// since the load started. It is guarded by mu.
+	loadsInFlight map[interface{}]loadChange
+	// evictionPauses is the number of PauseEviction calls not yet matched by
+	// ResumeEviction. It is guarded by mu.
+	evictionPauses int
+	// lastVersion is the version given to the last item set. It is guarded by mu.
+	lastVersion uint64
+	// lastSeq is the seq given to the last item inserted. It is guarded by mu.
+	lastSeq uint64
+	// order lists the keys in the order they were inserted. It is guarded by mu.
+	order insertionOrder
+	// tags is the tag index of the items set with SetWithTags, or nil if
+	// there were none. It is guarded by mu.
+	tags *tagIndex
+	// loaderErrorsSweep is the size of loaderErrors at which its expired
+	// errors are swept out next. It is guarded by loaderErrorsMu.
+	loaderErrorsSweep int
+	// pending holds the callbacks queued while mu is held, which unlock runs
+	// once it is released. It is guarded by mu.
+	pending []func()
+	*stats
+}
+
+// unlock releases mu and then runs the callbacks queued while it was held,
+// in the order they were queued, so they may call back into the cache.
+func (c *baseCache) unlock() {
+	pending := c.pending
+	c.pending = nil
+	c.mu.Unlock()
+	for _, fn := range pending {
+		fn()
+	}
+}
+
+// enqueue queues fn to run once mu is released. It must be called with mu held.
+func (c *baseCache) enqueue(fn func()) {
+	c.pending = append(c.pending, fn)
+}
+
+// audit records an operation in the audit log, if there is one.
+func (c *baseCache) audit(op string, key interface{}, result string) {
+	if c.auditLog != nil {
+		c.auditLog.record(AuditEntry{Time: c.clock.Now(), Op: op, Key: c.decodeKey(key), Result: result})
+	}
+}
+
+// recordGet counts a get of key as a hit or a miss.
+func (c *baseCache) recordGet(key interface{}, hit bool) {
+	if hit {
+		c.stats.IncrHitCount()
+		c.audit(AuditGet, key, AuditHit)
+	} else {
+		misses := c.stats.IncrMissCount()
+		c.audit(AuditGet, key, AuditMiss)
+		c.checkZeroHits(misses)
+	}
+}
+
+// checkZeroHits warns once if the first warnOnZeroHits lookups all missed.
+func (c *baseCache) checkZeroHits(misses uint64) {
+	if c.warnOnZeroHits == 0 || misses < c.warnOnZeroHits || c.logger == nil {
+		return
+	}
+	if c.stats.HitCount() == 0 && atomic.CompareAndSwapUint32(&c.zeroHitsWarned, 0, 1) {
+		c.logger.Printf("gcache: no hits in %d lookups; check that keys are read in the form they are written and that the expiration is not too short", misses)
+	}
+}
+
+// PauseEviction stops Set from evicting items until ResumeEviction is called.
+func (c *baseCache) PauseEviction() {
+	c.mu.Lock()
+	defer c.unlock()
+	c.evictionPauses++
+}
+
+// ResumeEviction ends a pause and evicts the overflow once no pause is left.
+func (c *baseCache) ResumeEviction() {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.evictionPauses == 0 {
+		return
+	}
+	c.evictionPauses--
+	if c.evictionPauses == 0 {
+		c.cache.evictOverflow()
+	}
+}
+
+// nextVersion returns the version of an item being set. Versions increase
+// across the whole cache, so a key never gets a version back after a removal.
+func (c *baseCache) nextVersion() uint64 {
+	c.lastVersion++
+	return c.lastVersion
+}
+
+// recordSet records that key was set.
+func (c *baseCache) recordSet(key interface{}) {
+	c.audit(AuditSet, key, AuditOK)
+	if c.recentlySet != nil {
+		c.recentlySet.record(key)
+	}
+}
+
+// recordRemove records an explicit removal of key and returns ok.
+func (c *baseCache) recordRemove(key interface{}, ok bool) bool {
+	c.invalidateLoad(key, loadRemoved)
+	if ok {
+		c.audit(AuditRemove, key, AuditOK)
+	} else {
+		c.audit(AuditRemove, key, AuditNotFound)
+	}
+	return ok
+}
+
+// Type returns the eviction type of the cache.
+func (c *baseCache) Type() string {
+	return c.tp
+}
+
+// RecentOps returns the operations in the audit log, oldest first.
+// It returns nil if the cache was built without AuditLog.
+func (c *baseCache) RecentOps() []AuditEntry {
+	if c.auditLog == nil {
+		return nil
+	}
+	return c.auditLog.snapshot()
+}
+
+// RecentlySet returns up to n of the distinct keys most recently set, most recent first.
+// It returns nil if the cache was built without TrackRecentlySet.
+func (c *baseCache) RecentlySet(n int) []interface{} {
+	if c.recentlySet == nil {
+		return nil
+	}
+	keys := c.recentlySet.latest(n)
+	for i, key := range keys {
+		keys[i] = c.decodeKey(key)
+	}
+	return keys
+}
+
+// ResetStats clears the stats of the cache.
+func (c *baseCache) ResetStats() {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	c.stats.reset(c.count())
+}
+
+// Stats returns a snapshot of the stats of the cache.
+func (c *baseCache) Stats() StatsSnapshot {
+	s := c.stats.Stats()
+	c.mu.RLock()
+	s.Len = c.count()
+	c.mu.RUnlock()
+	return s
+}
+
+// count returns the number of items in the cache, including expired ones.
+func (c *baseCache) count() int {
+	n := 0
+	c.cache.forEach(func(*cacheItem) bool {
+		n++
+		return true
+	})
+	return n
+}
+
+// existed records the result of an Existed call in the stats if configured to.
+func (c *baseCache) existed(ok bool) bool {
+	if c.countExistedInStats {
+		if ok {
+			c.stats.IncrHitCount()
+		} else {
+			c.stats.IncrMissCount()
+		}
+	}
+	return ok
+}
+
+// soft wraps value in a soft value if the cache was built with SoftValues.
+func (c *baseCache) soft(value interface{}) interface{} {
+	if !c.softValues {
+		return value
+	}
+	return soften(value)
+}
+
+// serialized is a value already converted into its stored form, which
+// serialize passes through.
+type serialized struct {
+	value interface{}
+}
+
+// serialize converts value into its stored form.
+func (c *baseCache) serialize(key, value interface{}) (interface{}, error) {
+	if s, ok := value.(serialized); ok {
+		return s.value, nil
+	}
+	if c.serializeFunc == nil || c.lazySerialize {
+		return value, nil
+	}
+	return c.serializeFunc(c.decodeKey(key), value)
+}
+
+// deserialize converts a stored value back into the form returned to callers.
+func (c *baseCache) deserialize(key, value interface{}) (interface{}, error) {
+	if c.deserializeFunc == nil || c.lazySerialize {
+		return value, nil
+	}
+	return c.deserializeFunc(c.decodeKey(key), value)
+}
+
+// export returns the copy of value made by copyOnExport, if any, for a value
+// handed out by GetALL or Snapshot.
+func (c *baseCache) export(value interface{}) interface{} {
+	if c.copyOnExport == nil {
+		return value
+	}
+	return c.copyOnExport(value)
+}
+
+// spill converts a stored value into the form handed to evictedFunc and
+// purgeVisitorFunc. It is the only place lazily serialized values get encoded;
+// if encoding fails the native value is passed on.
+func (c *baseCache) spill(key, value interface{}) interface{} {
+	value = hardValue(value)
+	if c.serializeFunc == nil || !c.lazySerialize {
+		return value
+	}
+	if v, err := c.serializeFunc(c.decodeKey(key), value); err == nil {
+		return v
+	}
+	return value
+}
+
+// notifyEvicted queues the evictedFunc call, if any, and the eviction event
+// for an item removed from the cache.
+func (c *baseCache) notifyEvicted(key, value interface{}) {
+	c.untag(key)
+	c.audit(AuditEvict, key, AuditOK)
+	c.stats.IncrEvictCount()
+	if c.evictedFunc == nil && c.evictionChan == nil {
+		return
+	}
+	c.enqueue(func() {
+		if c.evictedFunc != nil {
+			value := c.spill(key, value)
+			key := c.decodeKey(key)
+			start := time.Now()
+			c.evictedFunc(key, value)
+			c.timeCallback("evictedFunc", key, time.Since(start))
+		}
+		if c.evictionChan != nil {
+			c.evictionChan.send(EvictionEvent{Key: c.decodeKey(key), Value: c.spill(key, value)})
+		}
+	})
+}
+
+// notifyExpired queues the expiredFunc call, if any, for an item removed from
+// the cache because it expired. It is called after notifyEvicted.
+func (c *baseCache) notifyExpired(key, value interface{}) {
+	if c.expiredFunc == nil {
+		return
+	}
+	c.enqueue(func() {
+		value := c.spill(key, value)
+		key := c.decodeKey(key)
+		start := time.Now()
+		c.expiredFunc(key, value)
+		c.timeCallback("expiredFunc", key, time.Since(start))
+	})
+}
+
+// notifyAdded queues the addedFunc call, if any, for an item stored in the cache.
+func (c *baseCache) notifyAdded(key, value interface{}) {
+	if c.addedFunc == nil {
+		return
+	}
+	c.enqueue(func() {
+		c.addedFunc(c.decodeKey(key), value)
+	})
+}
+
+// notifyPurged queues the purgeVisitorFunc call, if any, for an item removed
+// by Purge.
+func (c *baseCache) notifyPurged(key, value interface{}) {
+	if c.purgeVisitorFunc == nil {
+		return
+	}
+	c.enqueue(func() {
+		value := c.spill(key, value)
+		key := c.decodeKey(key)
+		start := time.Now()
+		c.purgeVisitorFunc(key, value)
+		c.timeCallback("purgeVisitorFunc", key, time.Since(start))
+	})
+}
+
+// timeCallback records the duration d of an eviction callback for key and
+// warns about it if it exceeds the slow callback threshold.
+func (c *baseCache) timeCallback(name string, key interface{}, d time.Duration) {
+	c.stats.addEvictCallbackTime(d)
+	if c.logger != nil && c.slowCallback > 0 && d > c.slowCallback {
+		c.logger.Printf("gcache: slow %s for key %v took %v", name, key, d)
+	}
+}
+
+// checkKeyType returns ErrKeyTypeMismatch if key is not of the enforced key type.
+func (c *baseCache) checkKeyType(key interface{}) error {
+	if c.keyType == nil {
+		return nil
+	}
+	if t := reflect.TypeOf(key); t != c.keyType {
+		return fmt.Errorf("gcache: %w: got %v, want %v", ErrKeyTypeMismatch, t, c.keyType)
+	}
+	return nil
+}
+
+// encodeKey converts a key passed by the caller into the key stored in the cache.
+func (c *baseCache) encodeKey(key interface{}) interface{} {
+	if c.keyCodec == nil {
+		return key
+	}
+	return c.keyCodec.Encode(key)
+}
+
+// decodeKey converts a stored key back into the key reported to the caller.
+func (c *baseCache) decodeKey(key interface{}) interface{} {
+	if c.keyCodec == nil {
+		return key
+	}
+	return c.keyCodec.Decode(key)
+}
+
+// trackPeak records n as the peak number of items if it exceeds the previous one.
+func (c *baseCache) trackPeak(n int) {
+	if n > c.peakItems {
+		c.peakItems = n
+	}
+}
+
+// needCompact reports whether a map holding n items has shrunk far enough
+// below its peak size to be worth rebuilding.
+func (c *baseCache) needCompact(n int) bool {
+	return n*compactRatio <= c.peakItems
+}
+
+// equal reports whether two cached values are equal.
+func (c *baseCache) equal(a, b interface{}) bool {
+	if c.equalFunc == nil {
+		return reflect.DeepEqual(a, b)
+	}
+	return c.equalFunc(a, b)
+}
+
+// unchanged reports whether the live value cached for key equals value.
+func (c *baseCache) unchanged(key, value interface{}) bool {
+	item := c.cache.peek(key)
+	if item == nil {
+		return false
+	}
+	v, ok := item.liveValue(nil)
+	if !ok {
+		return false
+	}
+	v, err := c.deserialize(key, v)
+	return err == nil && c.equal(v, value)
+}
+
+func (c *baseCache) Set(key, value interface{}) error {
+	if err := c.checkKeyType(key); err != nil {
+		return err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	c.invalidateLoad(key, loadOverwritten)
+	if c.skipUnchangedSet && c.unchanged(key, value) {
+		return nil
+	}
+	_, err := c.cache.set(key, value)
+	return err
+}
+
+func (c *baseCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
+	if err := c.checkKeyType(key); err != nil {
+		return err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	if expiration < 0 {
+		c.cache.remove(key)
+		c.invalidateLoad(key, loadRemoved)
+		return nil
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	item, err := c.cache.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	item.(*cacheItem).expiration = c.expirationFor(expiration)
+	return nil
+}
+
+func (c *baseCache) SetAll(entries map[interface{}]interface{}) error {
+	return c.setAll(entries, nil)
+}
+
+func (c *baseCache) SetAllWithExpire(entries map[interface{}]interface{}, expiration time.Duration) error {
+	return c.setAll(entries, &expiration)
+}
+
+// setAll sets entries with the given expiration, or the default one if nil.
+func (c *baseCache) setAll(entries map[interface{}]interface{}, expiration *time.Duration) error {
+	c.mu.Lock()
+	defer c.unlock()
+	var errs MultiError
+	for k, value := range entries {
+		if err := c.checkKeyType(k); err != nil {
+			errs.add(k, err)
+			break
+		}
+		key := c.encodeKey(k)
+		if expiration != nil && *expiration < 0 {
+			c.cache.remove(key)
+			c.invalidateLoad(key, loadRemoved)
+			continue
+		}
+		c.invalidateLoad(key, loadOverwritten)
+		item, err := c.cache.set(key, value)
+		if err != nil {
+			errs.add(k, err)
+			break
+		}
+		if expiration != nil {
+			item.(*cacheItem).expiration = c.expirationFor(*expiration)
+		}
+	}
+	return errs.errOrNil()
+}
+
+func (c *baseCache) SetWithExpireAt(key, value interface{}, expireAt time.Time) error {
+	if err := c.checkKeyType(key); err != nil {
+		return err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	c.invalidateLoad(key, loadOverwritten)
+	item, err := c.cache.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	if expireAt.IsZero() {
+		item.(*cacheItem).expiration = nil
+	} else {
+		item.(*cacheItem).expiration = &expireAt
+	}
+	return nil
+}
+
+// SetWithPolicy sets a value with its own time to live and time to idle.
+func (c *baseCache) SetWithPolicy(key, value interface{}, ttl, tti time.Duration) error {
+	if err := c.checkKeyType(key); err != nil {
+		return err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	if ttl < 0 {
+		c.cache.remove(key)
+		c.invalidateLoad(key, loadRemoved)
+		return nil
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	item, err := c.cache.set(key, value)
+	if err != nil {
+		return err
+	}
+
+	it := item.(*cacheItem)
+	if ttl > 0 {
+		it.expiration = c.expirationFor(ttl)
+	}
+	if tti > 0 {
+		it.setMaxIdle(tti)
+	}
+	return nil
+}
+
+// Version returns the version of the live item for key.
+func (c *baseCache) Version(key interface{}) (uint64, bool) {
+	if c.checkKeyType(key) != nil {
+		return 0, false
+	}
+	key = c.encodeKey(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.liveVersion(key)
+}
+
+// liveVersion returns the version of the live item for key, or false.
+func (c *baseCache) liveVersion(key interface{}) (uint64, bool) {
+	item := c.cache.peek(key)
+	if item == nil {
+		return 0, false
+	}
+	if _, ok := item.liveValue(nil); !ok {
+		return 0, false
+	}
+	return item.version, true
+}
+
+// CompareVersionAndSwap sets value for key if the version of key is still version.
+func (c *baseCache) CompareVersionAndSwap(key interface{}, version uint64, value interface{}) (bool, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return false, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	if current, _ := c.liveVersion(key); current != version {
+		return false, nil
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	if _, err := c.cache.set(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// SetIfAbsent sets value for key only if key is missing or expired.
+func (c *baseCache) SetIfAbsent(key, value interface{}) (bool, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return false, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	if _, ok := c.liveVersion(key); ok {
+		return false, nil
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	if _, err := c.cache.set(key, value); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap sets new for key if its live value equals old.
+func (c *baseCache) CompareAndSwap(key, old, new interface{}) (bool, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return false, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	if !c.unchanged(key, old) {
+		return false, nil
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	if _, err := c.cache.set(key, new); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// setWithoutAccess sets key like set, but never counts it as an access to
+// the key. It must be called with mu held.
+func (c *baseCache) setWithoutAccess(key, value interface{}) (interface{}, error) {
+	access := c.setCountsAsAccess
+	c.setCountsAsAccess = false
+	defer func() { c.setCountsAsAccess = access }()
+	return c.cache.set(key, value)
+}
+
+// liveValueOrDrop returns the live stored value of key. It removes an expired
+// item, so that a value set next does not inherit its expiration.
+func (c *baseCache) liveValueOrDrop(key interface{}) (interface{}, bool) {
+	item := c.cache.peek(key)
+	if item == nil {
+		return nil, false
+	}
+	v, ok := item.liveValue(nil)
+	if !ok {
+		c.cache.remove(key)
+		c.notifyExpired(key, item.value)
+	}
+	return v, ok
+}
+
+// Update sets for key the value returned by fn for its current value.
+func (c *baseCache) Update(key interface{}, fn func(old interface{}, exists bool) (interface{}, error)) error {
+	if err := c.checkKeyType(key); err != nil {
+		return err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	old, exists := c.liveValueOrDrop(key)
+	if exists {
+		var err error
+		if old, err = c.deserialize(key, old); err != nil {
+			return err
+		}
+	}
+	value, err := fn(old, exists)
+	if err != nil {
+		return err
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	_, err = c.cache.set(key, value)
+	return err
+}
+
+// Increment adds delta to the numeric value of key.
+func (c *baseCache) Increment(key interface{}, delta int64) (int64, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return 0, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	old, exists := c.liveValueOrDrop(key)
+	c.recordGet(key, exists)
+	if !exists {
+		c.invalidateLoad(key, loadOverwritten)
+		_, err := c.cache.set(key, delta)
+		return delta, err
+	}
+	old, err := c.deserialize(key, old)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	var value interface{}
+	switch v := old.(type) {
+	case int:
+		var ok bool
+		n, ok = addInt64(int64(v), delta)
+		if !ok || int64(int(n)) != n {
+			return 0, ErrOverflow
+		}
+		value = int(n)
+	case int64:
+		var ok bool
+		if n, ok = addInt64(v, delta); !ok {
+			return 0, ErrOverflow
+		}
+		value = n
+	case float64:
+		f := v + float64(delta)
+		if f != math.Trunc(f) {
+			return 0, ErrNotANumber
+		}
+		if f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, ErrOverflow
+		}
+		n, value = int64(f), f
+	default:
+		return 0, ErrNotANumber
+	}
+	expiration := c.cache.peek(key).expiration
+	c.invalidateLoad(key, loadOverwritten)
+	item, err := c.cache.set(key, value)
+	if err != nil {
+		return 0, err
+	}
+	item.(*cacheItem).expiration = expiration
+	return n, nil
+}
+
+// addInt64 returns a+b, or false if it overflows.
+func addInt64(a, b int64) (int64, bool) {
+	if b > 0 && a > math.MaxInt64-b || b < 0 && a < math.MinInt64-b {
+		return 0, false
+	}
+	return a + b, true
+}
+
+// Decrement subtracts delta from the numeric value of key.
+func (c *baseCache) Decrement(key interface{}, delta int64) (int64, error) {
+	return c.Increment(key, -delta)
+}
+
+// Swap sets newValue for key and returns the previous live value.
+func (c *baseCache) Swap(key, newValue interface{}) (interface{}, bool, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, false, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	old, existed := c.liveValueOrDrop(key)
+	c.invalidateLoad(key, loadOverwritten)
+	var err error
+	if _, ok := c.cache.(*lfuCache); ok {
+		// Replacing the value keeps the frequency of the key.
+		_, err = c.setWithoutAccess(key, newValue)
+	} else {
+		_, err = c.cache.set(key, newValue)
+	}
+	c.unlock()
+	if err != nil || !existed {
+		return nil, false, err
+	}
+	old, err = c.deserialize(key, old)
+	return old, true, err
+}
+
+// GetOrSet returns the value of key if it is present, or sets value.
+func (c *baseCache) GetOrSet(key, value interface{}) (interface{}, bool, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, false, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	if v, ok := c.liveValueOrDrop(key); ok {
+		c.cache.access(key)
+		c.unlock()
+		v, err := c.deserialize(key, v)
+		c.recordGet(key, err == nil)
+		if err != nil {
+			return nil, false, err
+		}
+		return v, true, nil
+	}
+	c.invalidateLoad(key, loadOverwritten)
+	_, err := c.cache.set(key, value)
+	c.unlock()
+	if err != nil {
+		return nil, false, err
+	}
+	c.recordGet(key, false)
+	return value, false, nil
+}
+
+// Snapshot returns all live key-value pairs, deserializing them outside the lock.
+// Values which fail to deserialize are omitted.
+func (c *baseCache) Snapshot() map[interface{}]interface{} {
+	stored := make(map[interface{}]interface{})
+	c.mu.RLock()
+	now := c.clock.Now()
+	c.cache.forEach(func(item *cacheItem) bool {
+		if v, ok := item.liveValue(&now); ok {
+			stored[item.key] = v
+		}
+		return true
+	})
+	c.mu.RUnlock()
+
+	items := make(map[interface{}]interface{}, len(stored))
+	for k, v := range stored {
+		v, err := c.deserialize(k, v)
+		if err != nil {
+			continue
+		}
+		items[c.decodeKey(k)] = c.export(v)
+	}
+	return items
+}
+
+// GetAllByPrefix returns the key-value pairs whose string key starts with prefix.
+func (c *baseCache) GetAllByPrefix(prefix string, checkExpired bool) map[string]interface{} {
+	type stored struct {
+		key   interface{}
+		value interface{}
+	}
+	matched := make(map[string]stored)
+	c.mu.RLock()
+	now := c.clock.Now()
+	c.cache.forEach(func(item *cacheItem) bool {
+		key, ok := c.decodeKey(item.key).(string)
+		if !ok || !strings.HasPrefix(key, prefix) {
+			return true
+		}
+		if !checkExpired {
+			matched[key] = stored{item.key, hardValue(item.value)}
+		} else if v, ok := item.liveValue(&now); ok {
+			matched[key] = stored{item.key, v}
+		}
+		return true
+	})
+	c.mu.RUnlock()
+
+	items := make(map[string]interface{}, len(matched))
+	for k, item := range matched {
+		v, err := c.deserialize(item.key, item.value)
+		if err != nil {
+			continue
+		}
+		items[k] = v
+	}
+	return items
+}
+
+// RemoveAll removes keys under a single lock.
+func (c *baseCache) RemoveAll(keys ...interface{}) int {
+	c.mu.Lock()
+	defer c.unlock()
+	removed := 0
+	for _, key := range keys {
+		if c.checkKeyType(key) != nil {
+			continue
+		}
+		key = c.encodeKey(key)
+		if c.recordRemove(key, c.cache.remove(key)) {
+			removed++
+		}
+	}
+	return removed
+}
+
+// Pop removes key and returns its value.
+func (c *baseCache) Pop(key interface{}) (interface{}, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	item := c.cache.peek(key)
+	if item == nil {
+		c.unlock()
+		return nil, ErrKeyNotFound
+	}
+	v, ok := item.liveValue(nil)
+	c.recordRemove(key, c.cache.remove(key))
+	if !ok {
+		c.notifyExpired(key, item.value)
+		c.unlock()
+		return nil, ErrKeyNotFound
+	}
+	c.unlock()
+	return c.deserialize(key, v)
+}
+
+// RemoveByPrefix removes the items whose string key starts with prefix.
+func (c *baseCache) RemoveByPrefix(prefix string) int {
+	return c.removeIf(func(item *cacheItem) bool {
+		s, ok := c.decodeKey(item.key).(string)
+		return ok && strings.HasPrefix(s, prefix)
+	})
+}
+
+// RemoveIf removes the live items satisfying pred.
+func (c *baseCache) RemoveIf(pred func(key, value interface{}) bool) int {
+	now := c.clock.Now()
+	return c.removeIf(func(item *cacheItem) bool {
+		v, ok := item.liveValue(&now)
+		if !ok {
+			return false
+		}
+		v, err := c.deserialize(item.key, v)
+		return err == nil && pred(c.decodeKey(item.key), v)
+	})
+}
+
+// removeIf removes the items satisfying pred and returns the number of items
+// removed.
+func (c *baseCache) removeIf(pred func(item *cacheItem) bool) int {
+	c.mu.Lock()
+	defer c.unlock()
+	var keys []interface{}
+	c.cache.forEach(func(item *cacheItem) bool {
+		if pred(item) {
+			keys = append(keys, item.key)
+		}
+		return true
+	})
+	for _, key := range keys {
+		c.recordRemove(key, c.cache.remove(key))
+	}
+	return len(keys)
+}
+
+// Values returns a slice of the values in the cache.
+func (c *baseCache) Values(checkExpired bool) []interface{} {
+	type stored struct{ key, value interface{} }
+	c.mu.RLock()
+	var items []stored
+	now := c.clock.Now()
+	c.cache.forEach(func(item *cacheItem) bool {
+		if !checkExpired {
+			items = append(items, stored{item.key, hardValue(item.value)})
+		} else if v, ok := item.liveValue(&now); ok {
+			items = append(items, stored{item.key, v})
+		}
+		return true
+	})
+	c.mu.RUnlock()
+
+	values := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		v, err := c.deserialize(item.key, item.value)
+		if err != nil {
+			continue
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+// ExpiredKeys returns the keys of the expired items still in the cache.
+func (c *baseCache) ExpiredKeys() []interface{} {
+	var keys []interface{}
+	c.mu.RLock()
+	now := c.clock.Now()
+	c.cache.forEach(func(item *cacheItem) bool {
+		if item.IsExpired(&now) {
+			keys = append(keys, c.decodeKey(item.key))
+		}
+		return true
+	})
+	c.mu.RUnlock()
+	return keys
+}
+
+// GetALL returns all key-value pairs in the cache, deserializing the values
+// outside the lock. Values which fail to deserialize are omitted.
+func (c *baseCache) GetALL(checkExpired bool) map[interface{}]interface{} {
+	items := make(map[interface{}]interface{})
+	c.Range(checkExpired, func(key, value interface{}) bool {
+		items[key] = c.export(value)
+		return true
+	})
+	return items
+}
+
+// RangeExpired calls fn for every expired item still in the cache.
+func (c *baseCache) RangeExpired(fn func(key, value interface{}) bool) {
+	c.rangeItems(func(item *cacheItem, now *time.Time) bool {
+		return item.IsExpired(now)
+	}, fn)
+}
+
+// Range calls fn for every item, or every live one if checkExpired is true.
+func (c *baseCache) Range(checkExpired bool, fn func(key, value interface{}) bool) {
+	c.rangeItems(func(item *cacheItem, now *time.Time) bool {
+		return !checkExpired || !item.IsExpired(now)
+	}, fn)
+}
+
+// rangeItems calls fn for the items satisfying filter, until fn returns false.
+// It copies the items under the lock and calls fn after releasing it.
+func (c *baseCache) rangeItems(filter func(item *cacheItem, now *time.Time) bool, fn func(key, value interface{}) bool) {
+	type stored struct{ key, value interface{} }
+	var items []stored
+	c.mu.RLock()
+	now := c.clock.Now()
+	c.cache.forEach(func(item *cacheItem) bool {
+		if filter(item, &now) {
+			items = append(items, stored{item.key, hardValue(item.value)})
+		}
+		return true
+	})
+	c.mu.RUnlock()
+
+	for _, item := range items {
+		v, err := c.deserialize(item.key, item.value)
+		if err != nil {
+			continue
+		}
+		if !fn(c.decodeKey(item.key), v) {
+			return
+		}
+	}
+}
+
+// ExpireAll sets the expiration of every live item to now+expiration and
+// returns the number of items updated.
+func (c *baseCache) ExpireAll(expiration time.Duration) int {
+	c.mu.Lock()
+	defer c.unlock()
+	now := c.clock.Now()
+	var count int
+	c.cache.forEach(func(item *cacheItem) bool {
+		if !item.IsExpired(&now) {
+			item.expiration = c.expirationFor(expiration)
+			count++
+		}
+		return true
+	})
+	return count
+}
+
+// Touch sets the expiration of the live item for key.
+func (c *baseCache) Touch(key interface{}, expiration time.Duration) bool {
+	if c.checkKeyType(key) != nil {
+		return false
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	item := c.cache.peek(key)
+	if item == nil || item.IsExpired(nil) {
+		return false
+	}
+	item.expiration = c.expirationFor(expiration)
+	return true
+}
+
+// expirationFor returns the deadline of an item expiring after d,
+// or nil if d is zero and the item never expires.
+func (c *baseCache) expirationFor(d time.Duration) *time.Time {
+	if d == 0 {
+		return nil
+	}
+	t := c.clock.Now().Add(d)
+	return &t
+}
+
+// Get a value from cache pool using key if it exists. If not exists and it has LoaderFunc, it will generate the value using you have specified LoaderFunc method returns value.
+func (c *baseCache) Get(ctx context.Context, key interface{}) (interface{}, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
+	v, err := c.cache.get(key, false)
+	if err == ErrKeyNotFound {
+		return c.getWithLoader(ctx, key, c.loaderFunc(), true, false)
+	}
+	return v, err
+}
+
+// GetWithLoader gets a value from cache pool using key if it exists, or loads it with loader.
+func (c *baseCache) GetWithLoader(ctx context.Context, key interface{}, loader LoaderExpireFunc) (interface{}, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
+	if loader == nil {
+		loader = c.loaderFunc()
+	}
+	v, err := c.cache.get(key, false)
 	if err == ErrKeyNotFound {
-		return c.getWithLoader(ctx, key, true)
+		return c.getWithLoader(ctx, key, loader, true, false)
 	}
 	return v, err
 }
 
+// GetWithDefault gets a value from cache pool using key like Get, or returns defaultValue on failure.
+func (c *baseCache) GetWithDefault(ctx context.Context, key, defaultValue interface{}) interface{} {
+	v, err := c.Get(ctx, key)
+	if err != nil {
+		return defaultValue
+	}
+	return v
+}
+
+// GetMultiDetailed gets the values of keys and reports which of them were loaded.
+func (c *baseCache) GetMultiDetailed(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, map[interface{}]bool, error) {
+	values := make(map[interface{}]interface{}, len(keys))
+	loaded := make(map[interface{}]bool, len(keys))
+	var errs MultiError
+	for _, key := range keys {
+		if err := c.checkKeyType(key); err != nil {
+			errs.add(key, err)
+			continue
+		}
+		k := c.encodeKey(key)
+		v, err := c.cache.get(k, false)
+		fromLoader := err == ErrKeyNotFound
+		if fromLoader {
+			v, err = c.getWithLoader(ctx, k, c.loaderFunc(), true, false)
+		}
+		switch err {
+		case nil:
+			values[key] = v
+			loaded[key] = fromLoader
+		case ErrKeyNotFound:
+		default:
+			errs.add(key, err)
+		}
+	}
+	return values, loaded, errs.errOrNil()
+}
+
+// GetMulti gets the values of keys, loading the missing ones.
+func (c *baseCache) GetMulti(ctx context.Context, keys []interface{}) (map[interface{}]interface{}, error) {
+	values, _, err := c.GetMultiDetailed(ctx, keys)
+	return values, err
+}
+
+// GetIFPresentMulti gets the values of the keys which are present.
+func (c *baseCache) GetIFPresentMulti(keys []interface{}) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{}, len(keys))
+	var errs MultiError
+	for _, key := range keys {
+		if err := c.checkKeyType(key); err != nil {
+			errs.add(key, err)
+			continue
+		}
+		switch v, err := c.cache.get(c.encodeKey(key), false); err {
+		case nil:
+			values[key] = v
+		case ErrKeyNotFound:
+		default:
+			errs.add(key, err)
+		}
+	}
+	return values, errs.errOrNil()
+}
+
+// loadGroupKey keeps the group keys of GetWithLoadGroup apart from the cache
+// keys in the singleflight group.
+type loadGroupKey struct {
+	groupKey interface{}
+}
+
+// GetWithLoadGroup gets the value of key, loading it once per groupKey among concurrent misses.
+func (c *baseCache) GetWithLoadGroup(ctx context.Context, key, groupKey interface{}, loader func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
+	v, err := c.cache.get(key, false)
+	if err != ErrKeyNotFound {
+		return v, err
+	}
+	ctx = c.loadContext(ctx, key)
+	if c.dropLoadIfRemoved || c.explicitSetWins {
+		c.startLoad(key)
+	}
+	v, _, err = c.loadGroup.DoFresh(loadGroupKey{groupKey}, func() (v interface{}, e error) {
+		defer func() {
+			if r := recover(); r != nil {
+				e = fmt.Errorf("Loader panics: %v", r)
+			}
+		}()
+		c.recordLoad()
+		return loader(ctx)
+	})
+	return c.storeLoad(key, v, nil, err)
+}
+
 // GetIFPresent gets a value from cache pool using key if it exists.
 // If it dose not exists key, returns ErrKeyNotFound.
 // And send a request which refresh value for specified key if cache object has LoaderFunc.
 func (c *baseCache) GetIFPresent(key interface{}) (interface{}, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
 	v, err := c.cache.get(key, false)
 	if err == ErrKeyNotFound {
-		return c.getWithLoader(context.Background(), key, false)
+		return c.getWithLoader(c.backgroundContext(), key, c.loaderFunc(), false, false)
 	}
-	return v, nil
+	return v, err
+}
+
+// GetRaw returns the stored value of the live item for key without deserializing it.
+func (c *baseCache) GetRaw(key interface{}) (interface{}, bool) {
+	if c.checkKeyType(key) != nil {
+		return nil, false
+	}
+	key = c.encodeKey(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item := c.cache.peek(key)
+	if item == nil {
+		return nil, false
+	}
+	return item.liveValue(nil)
+}
+
+// GetExpiration returns the earliest of the expiration and the idle expiration
+// of the item for key.
+func (c *baseCache) GetExpiration(key interface{}) (time.Time, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return time.Time{}, err
+	}
+	key = c.encodeKey(key)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	item := c.cache.peek(key)
+	if item == nil || item.IsExpired(nil) {
+		return time.Time{}, ErrKeyNotFound
+	}
+	return item.expireAt(), nil
+}
+
+// GetMultiWithExpiration returns the value and expiration of every given key
+// that is present and not expired. Keys whose value fails to deserialize are omitted.
+func (c *baseCache) GetMultiWithExpiration(keys []interface{}) map[interface{}]Entry {
+	entries := make(map[interface{}]Entry, len(keys))
+	// stored holds the stored key of every entry, which deserialize expects.
+	stored := make(map[interface{}]interface{}, len(keys))
+	c.mu.RLock()
+	now := c.clock.Now()
+	for _, key := range keys {
+		item := c.cache.peek(c.encodeKey(key))
+		if item == nil {
+			continue
+		}
+		v, ok := item.liveValue(&now)
+		if !ok {
+			continue
+		}
+		entries[key] = Entry{Value: v, ExpireAt: item.expireAt(), Version: item.version}
+		stored[key] = item.key
+	}
+	c.mu.RUnlock()
+
+	if c.deserializeFunc != nil {
+		for key, entry := range entries {
+			v, err := c.deserialize(stored[key], entry.Value)
+			if err != nil {
+				delete(entries, key)
+				continue
+			}
+			entry.Value = v
+			entries[key] = entry
+		}
+	}
+	return entries
+}
+
+// setFuncKey keeps the keys of GetOrSetWithTTLFunc apart from the keys being
+// loaded in the singleflight group.
+type setFuncKey struct {
+	key interface{}
+}
+
+// GetOrSetWithTTLFunc returns the value for key if it is present, or stores
+// and returns the value computed by fn.
+func (c *baseCache) GetOrSetWithTTLFunc(key interface{}, fn func() (interface{}, time.Duration, error)) (interface{}, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
+	v, err := c.cache.get(key, false)
+	if err != ErrKeyNotFound {
+		return v, err
+	}
+	// panicked is the panic of fn, which the callers sharing its call get as
+	// an error while it is raised again in the caller which ran it.
+	var panicked interface{}
+	v, _, err = c.loadGroup.DoFresh(setFuncKey{key}, func() (v interface{}, e error) {
+		defer func() {
+			if r := recover(); r != nil {
+				panicked = r
+				e = fmt.Errorf("GetOrSetWithTTLFunc panics: %v", r)
+			}
+		}()
+		// A previous call may have stored the value since the miss above.
+		if v, err := c.cache.get(key, true); err == nil {
+			return v, nil
+		}
+		value, ttl, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		c.mu.Lock()
+		defer c.unlock()
+		if ttl < 0 {
+			c.cache.remove(key)
+			c.invalidateLoad(key, loadRemoved)
+			return value, nil
+		}
+		c.invalidateLoad(key, loadOverwritten)
+		item, err := c.cache.set(key, value)
+		if err != nil {
+			return nil, err
+		}
+		item.(*cacheItem).expiration = c.expirationFor(ttl)
+		return value, nil
+	})
+	if panicked != nil {
+		panic(panicked)
+	}
+	return v, err
+}
+
+// loadingKeysKey is the context key for the keys being loaded on the call stack.
+type loadingKeysKey struct{}
+
+// loadingKeys is a stack of the keys being loaded by nested loader calls.
+type loadingKeys struct {
+	key    interface{}
+	parent *loadingKeys
+}
+
+// isLoading reports whether key is being loaded by a loader further up the call stack.
+func isLoading(ctx context.Context, key interface{}) bool {
+	lk, _ := ctx.Value(loadingKeysKey{}).(*loadingKeys)
+	for ; lk != nil; lk = lk.parent {
+		if lk.key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// withLoadingKey returns a copy of ctx which records that key is being loaded.
+func withLoadingKey(ctx context.Context, key interface{}) context.Context {
+	parent, _ := ctx.Value(loadingKeysKey{}).(*loadingKeys)
+	return context.WithValue(ctx, loadingKeysKey{}, &loadingKeys{key: key, parent: parent})
+}
+
+// loaderError is an error returned by the loader, cached until expiration.
+type loaderError struct {
+	err        error
+	expiration time.Time
+}
+
+// cachedLoaderError returns the cached loader error for key, if any.
+func (c *baseCache) cachedLoaderError(key interface{}) error {
+	if c.loaderErrorTTL <= 0 {
+		return nil
+	}
+	c.loaderErrorsMu.Lock()
+	defer c.loaderErrorsMu.Unlock()
+	le, ok := c.loaderErrors[key]
+	if !ok {
+		return nil
+	}
+	if le.expiration.Before(c.clock.Now()) {
+		delete(c.loaderErrors, key)
+		return nil
+	}
+	return le.err
+}
+
+// cacheLoaderError caches err returned by the loader for key.
+func (c *baseCache) cacheLoaderError(key interface{}, err error) {
+	if c.loaderErrorTTL <= 0 {
+		return
+	}
+	c.loaderErrorsMu.Lock()
+	defer c.loaderErrorsMu.Unlock()
+	if c.loaderErrors == nil {
+		c.loaderErrors = make(map[interface{}]loaderError)
+	}
+	now := c.clock.Now()
+	if len(c.loaderErrors) >= c.loaderErrorsSweep {
+		// Sweeping once the map doubled keeps it within twice the number of
+		// unexpired errors at a constant amortized cost per insert.
+		for k, le := range c.loaderErrors {
+			if le.expiration.Before(now) {
+				delete(c.loaderErrors, k)
+			}
+		}
+		c.loaderErrorsSweep = maxInt(2*len(c.loaderErrors), minLoaderErrorsSweep)
+	}
+	c.loaderErrors[key] = loaderError{err: err, expiration: now.Add(c.loaderErrorTTL)}
+}
+
+// minLoaderErrorsSweep is the smallest size of loaderErrors which is swept.
+const minLoaderErrorsSweep = 64
+
+// clearLoaderErrors forgets all the cached loader errors.
+func (c *baseCache) clearLoaderErrors() {
+	c.loaderErrorsMu.Lock()
+	defer c.loaderErrorsMu.Unlock()
+	c.loaderErrors = nil
+	c.loaderErrorsSweep = 0
+}
+
+// recordLoad counts a loader call and calls onLoadRateExceeded if it makes
+// the load rate rise above maxLoadRate.
+func (c *baseCache) recordLoad() {
+	rate := c.loadRate.record(c.clock.Now())
+	if c.onLoadRateExceeded != nil && c.maxLoadRate > 0 && c.loadRate.checkExceeded(rate, c.maxLoadRate) {
+		c.onLoadRateExceeded()
+	}
+}
+
+// LoadRatePerSecond returns the number of loader calls in the last second.
+func (c *baseCache) LoadRatePerSecond() float64 {
+	return c.loadRate.rate(c.clock.Now())
 }
 
 // load a new value using by specified key.
-func (c *baseCache) load(ctx context.Context, key interface{}, cb func(interface{}, *time.Duration, error) (interface{}, error), isWait bool) (interface{}, bool, error) {
-	v, called, err := c.loadGroup.Do(key, func() (v interface{}, e error) {
+// If fresh is set, the loader is called even if key is cached.
+func (c *baseCache) load(ctx context.Context, key interface{}, loader LoaderExpireFunc, cb func(interface{}, *time.Duration, error) (interface{}, error), isWait, fresh bool) (interface{}, bool, error) {
+	if isLoading(ctx, key) {
+		return nil, false, ErrLoaderRecursion
+	}
+	if err := c.cachedLoaderError(key); err != nil {
+		return nil, false, err
+	}
+	ctx = withLoadingKey(ctx, key)
+	fn := func() (v interface{}, e error) {
 		defer func() {
 			if r := recover(); r != nil {
 				e = fmt.Errorf("Loader panics: %v", r)
 			}
 		}()
-		return cb(c.loaderExpireFunc(ctx, key))
-	}, isWait)
+		c.recordLoad()
+		value, expiration, err := loader(ctx, c.decodeKey(key))
+		if err != nil {
+			c.cacheLoaderError(key, err)
+		}
+		return cb(value, expiration, err)
+	}
+	var (
+		v      interface{}
+		called bool
+		err    error
+	)
+	if fresh {
+		v, called, err = c.loadGroup.DoFresh(key, fn)
+	} else {
+		v, called, err = c.loadGroup.Do(key, fn, isWait)
+	}
 	if err != nil {
 		return nil, called, err
 	}
 	return v, called, nil
 }
 
-func (c *baseCache) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
-	if c.loaderExpireFunc == nil {
+func (c *baseCache) getWithLoader(ctx context.Context, key interface{}, loader LoaderExpireFunc, isWait, fresh bool) (interface{}, error) {
+	if loader == nil {
 		return nil, ErrKeyNotFound
 	}
-	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
-		if e != nil {
-			return nil, e
-		}
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		item, err := c.cache.set(key, v)
-		if err != nil {
-			return nil, err
-		}
-		if expiration != nil {
-			t := c.clock.Now().Add(*expiration)
-			item.(*cacheItem).expiration = &t
-		}
-		return v, nil
-	}, isWait)
+	ctx = c.loadContext(ctx, key)
+	if c.dropLoadIfRemoved || c.explicitSetWins {
+		loader = c.watchLoad(key, loader)
+	}
+	value, _, err := c.load(ctx, key, loader, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+		return c.storeLoad(key, v, expiration, e)
+	}, isWait, fresh)
 	if err != nil {
 		return nil, err
 	}
 	return value, nil
 }
 
+// storeLoad stores the value v loaded for key, unless the load failed or key
+// was changed while it ran and dropLoadIfRemoved or explicitSetWins says the
+// change wins. It returns v, or the error of the load.
+func (c *baseCache) storeLoad(key, v interface{}, expiration *time.Duration, e error) (interface{}, error) {
+	c.mu.Lock()
+	defer c.unlock()
+	change := c.endLoad(key)
+	if e != nil {
+		return nil, e
+	}
+	if change == loadRemoved && c.dropLoadIfRemoved || change == loadOverwritten && c.explicitSetWins {
+		return v, nil
+	}
+	item, err := c.cache.set(key, v)
+	if err != nil {
+		return nil, err
+	}
+	if expiration != nil {
+		t := c.clock.Now().Add(*expiration)
+		item.(*cacheItem).expiration = &t
+	}
+	return v, nil
+}
+
+// loaderFunc returns the current loader, which may be nil.
+func (c *baseCache) loaderFunc() LoaderExpireFunc {
+	loader, _ := c.loader.Load().(LoaderExpireFunc)
+	return loader
+}
+
+// SetLoader replaces the loader used by the loads started from now on.
+func (c *baseCache) SetLoader(loader LoaderExpireFunc) {
+	c.loader.Store(loader)
+}
+
+// loadContext returns the context passed to the loader of key: ctx, falling
+// back to the base context for values, or the base context alone if a nil ctx
+// was passed, so that loaders can rely on a usable context.
+func (c *baseCache) loadContext(ctx context.Context, key interface{}) context.Context {
+	if ctx == nil {
+		if c.logger != nil {
+			c.logger.Printf("gcache: nil context passed to load key %v", c.decodeKey(key))
+		}
+		return c.backgroundContext()
+	}
+	if c.baseCtx == nil || ctx == c.baseCtx {
+		return ctx
+	}
+	return mergedContext{Context: ctx, base: c.baseCtx}
+}
+
+// backgroundContext returns the context of the loads started by the cache
+// itself: the base context, or context.Background if there is none.
+func (c *baseCache) backgroundContext() context.Context {
+	if c.baseCtx != nil {
+		return c.baseCtx
+	}
+	return context.Background()
+}
+
+// mergedContext is a context which takes its deadline and cancellation from
+// the embedded per-call context and looks up values in base as well.
+type mergedContext struct {
+	context.Context
+	base context.Context
+}
+
+func (mc mergedContext) Value(key interface{}) interface{} {
+	if v := mc.Context.Value(key); v != nil {
+		return v
+	}
+	return mc.base.Value(key)
+}
+
+// ForceRefresh loads a new value for key even if it is cached and returns it
+// together with the previously cached value.
+func (c *baseCache) ForceRefresh(ctx context.Context, key interface{}) (interface{}, interface{}, bool, error) {
+	if err := c.checkKeyType(key); err != nil {
+		return nil, nil, false, err
+	}
+	key = c.encodeKey(key)
+	var (
+		old     interface{}
+		existed bool
+	)
+	c.mu.RLock()
+	if item := c.cache.peek(key); item != nil {
+		old, existed = item.liveValue(nil)
+	}
+	c.mu.RUnlock()
+	if existed {
+		// A value which fails to deserialize is still replaced.
+		old, _ = c.deserialize(key, old)
+	}
+	v, err := c.getWithLoader(ctx, key, c.loaderFunc(), true, true)
+	if err != nil {
+		return nil, old, existed, err
+	}
+	return v, old, existed, nil
+}
+
+// loadChange is how a key was changed while it was being loaded.
+type loadChange int
+
+const (
+	loadUnchanged loadChange = iota
+	loadRemoved
+	loadOverwritten
+)
+
+// watchLoad wraps loader so that changes of key are tracked while it runs.
+// The tracking is ended by storeLoad, or here if the loader panics.
+func (c *baseCache) watchLoad(key interface{}, loader LoaderExpireFunc) LoaderExpireFunc {
+	return func(ctx context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		c.startLoad(key)
+		returned := false
+		defer func() {
+			if !returned {
+				c.mu.Lock()
+				c.endLoad(key)
+				c.unlock()
+			}
+		}()
+		v, expiration, err := loader(ctx, k)
+		returned = true
+		return v, expiration, err
+	}
+}
+
+// startLoad starts tracking the changes of key for a load.
+func (c *baseCache) startLoad(key interface{}) {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.loadsInFlight == nil {
+		c.loadsInFlight = make(map[interface{}]loadChange)
+	}
+	c.loadsInFlight[key] = loadUnchanged
+}
+
+// invalidateLoad records that the running load of key, if any, was changed.
+func (c *baseCache) invalidateLoad(key interface{}, change loadChange) {
+	if _, ok := c.loadsInFlight[key]; ok {
+		c.loadsInFlight[key] = change
+	}
+}
+
+// endLoad stops tracking the load of key and returns the last change of key
+// while it ran.
+func (c *baseCache) endLoad(key interface{}) loadChange {
+	change := c.loadsInFlight[key]
+	delete(c.loadsInFlight, key)
+	return change
+}
+
 // load a new value using by specified key.
 func (c *baseCache) Refresh(ctx context.Context, key interface{}) (interface{}, error) {
-	return c.getWithLoader(ctx, key, true)
+	if err := c.checkKeyType(key); err != nil {
+		return nil, err
+	}
+	key = c.encodeKey(key)
+	return c.getWithLoader(ctx, key, c.loaderFunc(), true, false)
 }
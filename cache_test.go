@@ -4,6 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/gob"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -267,6 +274,74 @@ func TestDeserializeFunc(t *testing.T) {
 	}
 }
 
+func TestGetIFPresentDeserializeError(t *testing.T) {
+	errDeserialize := errors.New("cannot deserialize")
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+					return nil, errDeserialize
+				}).
+				Build()
+			cache.Set("key", 1)
+			v, err := cache.GetIFPresent("key")
+			if v != nil || err != errDeserialize {
+				t.Errorf("%v, %v != nil, %v", v, err, errDeserialize)
+			}
+			if hits, misses := cache.HitCount(), cache.MissCount(); hits != 0 || misses != 1 {
+				t.Errorf("%v, %v != 0, 1", hits, misses)
+			}
+		})
+	}
+}
+
+func TestGetALLDeserializes(t *testing.T) {
+	type point struct{ X, Y int }
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				SerializeFunc(func(k, v interface{}) (interface{}, error) {
+					if k == "bad" {
+						return []byte("not gob"), nil
+					}
+					buf := new(bytes.Buffer)
+					err := gob.NewEncoder(buf).Encode(v)
+					return buf.Bytes(), err
+				}).
+				DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+					var p point
+					err := gob.NewDecoder(bytes.NewBuffer(v.([]byte))).Decode(&p)
+					return p, err
+				}).
+				Build()
+			cache.Set("a", point{1, 2})
+			cache.Set("b", point{3, 4})
+			cache.Set("bad", point{5, 6})
+
+			expected := map[interface{}]interface{}{"a": point{1, 2}, "b": point{3, 4}}
+			for _, checkExpired := range []bool{false, true} {
+				if all := cache.GetALL(checkExpired); !reflect.DeepEqual(all, expected) {
+					t.Errorf("%v != %v", all, expected)
+				}
+			}
+		})
+	}
+}
+
 func TestExpiredItems(t *testing.T) {
 	var tps = []string{
 		TypeSimple,
@@ -280,3 +355,3655 @@ func TestExpiredItems(t *testing.T) {
 		})
 	}
 }
+
+func TestCompact(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			size := 100000
+			keep := 10
+			cache := New(size).EvictType(tp).Build()
+			setItemsByRange(t, cache, 0, size)
+			for i := keep; i < size; i++ {
+				cache.Remove(i)
+			}
+			cache.SetWithExpire(0, 0, time.Hour)
+
+			var before, after runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&before)
+			cache.Compact()
+			runtime.GC()
+			runtime.ReadMemStats(&after)
+
+			if after.HeapAlloc >= before.HeapAlloc {
+				t.Errorf("heap did not shrink: %v >= %v", after.HeapAlloc, before.HeapAlloc)
+			}
+			checkItemsByRange(t, cache.Keys(true), cache.GetALL(true), cache.Len(true), 0, keep)
+			if !cache.Existed(0) {
+				t.Error("expiration should be preserved")
+			}
+		})
+	}
+}
+
+func TestLazyExpireDisabled(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			for _, disabled := range []bool{false, true} {
+				fc := NewFakeClock()
+				var evictCounter int
+				cache := New(8).
+					EvictType(tp).
+					Clock(fc).
+					Expiration(time.Second).
+					LazyExpireDisabled(disabled).
+					EvictedFunc(func(key, value interface{}) {
+						evictCounter++
+					}).
+					Build()
+				cache.Set("key", "value")
+				fc.Advance(2 * time.Second)
+
+				if _, err := cache.GetIFPresent("key"); err != ErrKeyNotFound {
+					t.Fatalf("disabled=%v: %v != %v", disabled, err, ErrKeyNotFound)
+				}
+				expected := 0
+				if disabled {
+					expected = 1
+				}
+				if l := cache.Len(false); l != expected {
+					t.Errorf("disabled=%v: %v != %v", disabled, l, expected)
+				}
+				if evictCounter != 1-expected {
+					t.Errorf("disabled=%v: evicted %v times", disabled, evictCounter)
+				}
+			}
+		})
+	}
+}
+
+func TestExpiredUsesClock(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(time.Minute).
+				Build()
+			cache.Set("key", "value")
+
+			check := func(expected int) {
+				t.Helper()
+				if l := cache.Len(true); l != expected {
+					t.Errorf("%v != %v", l, expected)
+				}
+				if existed := cache.Existed("key"); existed != (expected == 1) {
+					t.Errorf("%v != %v", existed, expected == 1)
+				}
+				if keys := cache.Keys(true); len(keys) != expected {
+					t.Errorf("%v != %v", len(keys), expected)
+				}
+				if all := cache.GetALL(true); len(all) != expected {
+					t.Errorf("%v != %v", len(all), expected)
+				}
+			}
+			check(1)
+			fc.Advance(30 * time.Second)
+			check(1)
+			fc.Advance(time.Minute)
+			check(0)
+			if l := cache.Len(false); l != 1 {
+				t.Errorf("%v != %v", l, 1)
+			}
+		})
+	}
+}
+
+func TestGetExpiration(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).EvictType(tp).Clock(fc).Build()
+			cache.SetWithExpire("ttl", 1, time.Minute)
+			cache.Set("forever", 2)
+
+			expireAt, err := cache.GetExpiration("ttl")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := fc.Now().Add(time.Minute); !expireAt.Equal(expected) {
+				t.Errorf("%v != %v", expireAt, expected)
+			}
+			expireAt, err = cache.GetExpiration("forever")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !expireAt.IsZero() {
+				t.Errorf("%v is not zero", expireAt)
+			}
+			if _, err := cache.GetExpiration("missing"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			fc.Advance(2 * time.Minute)
+			if _, err := cache.GetExpiration("ttl"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			if hits, misses := cache.HitCount(), cache.MissCount(); hits != 0 || misses != 0 {
+				t.Errorf("%v, %v != 0, 0", hits, misses)
+			}
+		})
+	}
+}
+
+func TestTouch(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var added int
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(time.Minute).
+				AddedFunc(func(key, value interface{}) {
+					added++
+				}).
+				Build()
+			cache.Set("key", "value")
+
+			fc.Advance(50 * time.Second)
+			if !cache.Touch("key", time.Minute) {
+				t.Fatal("key should be touched")
+			}
+			fc.Advance(50 * time.Second)
+			if v, err := cache.GetIFPresent("key"); err != nil || v != "value" {
+				t.Errorf("%v, %v != value, nil", v, err)
+			}
+			fc.Advance(20 * time.Second)
+			if cache.Touch("key", time.Minute) {
+				t.Error("expired key should not be touched")
+			}
+			if cache.Touch("missing", time.Minute) {
+				t.Error("missing key should not be touched")
+			}
+			if added != 1 {
+				t.Errorf("%v != %v", added, 1)
+			}
+			if hits := cache.HitCount(); hits != 1 {
+				t.Errorf("%v != %v", hits, 1)
+			}
+		})
+	}
+}
+
+func TestSetWithExpireAt(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).EvictType(tp).Clock(fc).Expiration(time.Second).Build()
+			expireAt := fc.Now().Add(time.Minute)
+			cache.SetWithExpireAt("future", 1, expireAt)
+			cache.SetWithExpireAt("never", 2, time.Time{})
+			cache.SetWithExpireAt("past", 3, fc.Now().Add(-time.Second))
+
+			if got, err := cache.GetExpiration("future"); err != nil || !got.Equal(expireAt) {
+				t.Errorf("%v, %v != %v, nil", got, err, expireAt)
+			}
+			if l := cache.Len(false); l != 3 {
+				t.Errorf("%v != %v", l, 3)
+			}
+			if _, err := cache.GetIFPresent("past"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+
+			fc.Advance(59 * time.Second)
+			if v, err := cache.GetIFPresent("future"); err != nil || v != 1 {
+				t.Errorf("%v, %v != 1, nil", v, err)
+			}
+			fc.Advance(2 * time.Second)
+			if _, err := cache.GetIFPresent("future"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			if v, err := cache.GetIFPresent("never"); err != nil || v != 2 {
+				t.Errorf("%v, %v != 2, nil", v, err)
+			}
+		})
+	}
+}
+
+func TestSetIfAbsent(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var added int
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(time.Minute).
+				AddedFunc(func(key, value interface{}) {
+					added++
+				}).
+				Build()
+
+			if ok, err := cache.SetIfAbsent("key", 1); !ok || err != nil {
+				t.Errorf("%v, %v != true, nil", ok, err)
+			}
+			if ok, err := cache.SetIfAbsent("key", 2); ok || err != nil {
+				t.Errorf("%v, %v != false, nil", ok, err)
+			}
+			if v, _ := cache.GetIFPresent("key"); v != 1 {
+				t.Errorf("%v != %v", v, 1)
+			}
+			if expireAt, _ := cache.GetExpiration("key"); !expireAt.Equal(fc.Now().Add(time.Minute)) {
+				t.Errorf("%v != %v", expireAt, fc.Now().Add(time.Minute))
+			}
+
+			fc.Advance(2 * time.Minute)
+			if ok, err := cache.SetIfAbsent("key", 3); !ok || err != nil {
+				t.Errorf("%v, %v != true, nil", ok, err)
+			}
+			if v, _ := cache.GetIFPresent("key"); v != 3 {
+				t.Errorf("%v != %v", v, 3)
+			}
+			if added != 2 {
+				t.Errorf("%v != %v", added, 2)
+			}
+		})
+	}
+}
+
+func TestSetIfAbsentConcurrent(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).EvictType(tp).Build()
+			var wins int32
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					if ok, _ := cache.SetIfAbsent("key", i); ok {
+						atomic.AddInt32(&wins, 1)
+					}
+				}(i)
+			}
+			wg.Wait()
+			if wins != 1 {
+				t.Errorf("%v != %v", wins, 1)
+			}
+		})
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var added int
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(time.Minute).
+				AddedFunc(func(key, value interface{}) {
+					added++
+				}).
+				Build()
+
+			v, loaded, err := cache.GetOrSet("key", 1)
+			if v != 1 || loaded || err != nil {
+				t.Errorf("%v, %v, %v != 1, false, nil", v, loaded, err)
+			}
+			v, loaded, err = cache.GetOrSet("key", 2)
+			if v != 1 || !loaded || err != nil {
+				t.Errorf("%v, %v, %v != 1, true, nil", v, loaded, err)
+			}
+			fc.Advance(2 * time.Minute)
+			v, loaded, err = cache.GetOrSet("key", 3)
+			if v != 3 || loaded || err != nil {
+				t.Errorf("%v, %v, %v != 3, false, nil", v, loaded, err)
+			}
+			if added != 2 {
+				t.Errorf("%v != %v", added, 2)
+			}
+			if hits, misses := cache.HitCount(), cache.MissCount(); hits != 1 || misses != 2 {
+				t.Errorf("%v, %v != 1, 2", hits, misses)
+			}
+		})
+	}
+}
+
+func TestGetOrSetConcurrent(t *testing.T) {
+	var tps = []string{TypeSimple, TypeLru, TypeLfu, TypeArc}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).EvictType(tp).Build()
+			var inserts int32
+			values := make([]interface{}, 100)
+			var wg sync.WaitGroup
+			for i := range values {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					v, loaded, _ := cache.GetOrSet("key", i)
+					if !loaded {
+						atomic.AddInt32(&inserts, 1)
+					}
+					values[i] = v
+				}(i)
+			}
+			wg.Wait()
+			if inserts != 1 {
+				t.Errorf("%v != %v", inserts, 1)
+			}
+			for _, v := range values {
+				if v != values[0] {
+					t.Errorf("%v != %v", v, values[0])
+				}
+			}
+			if hits, misses := cache.HitCount(), cache.MissCount(); hits != 99 || misses != 1 {
+				t.Errorf("%v, %v != 99, 1", hits, misses)
+			}
+		})
+	}
+}
+
+func TestGetOrSetPromotes(t *testing.T) {
+	cache := New(2).LRU().Build()
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.GetOrSet("a", 3)
+	cache.Set("c", 3)
+	if !cache.Existed("a") || cache.Existed("b") {
+		t.Error("GetOrSet should move a hit to the front")
+	}
+}
+
+func TestCompareAndSwap(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).EvictType(tp).Clock(fc).Build()
+			cache.SetWithExpire("key", 1, time.Minute)
+			expireAt, _ := cache.GetExpiration("key")
+
+			if ok, err := cache.CompareAndSwap("key", 2, 3); ok || err != nil {
+				t.Errorf("%v, %v != false, nil", ok, err)
+			}
+			if ok, err := cache.CompareAndSwap("key", 1, 3); !ok || err != nil {
+				t.Errorf("%v, %v != true, nil", ok, err)
+			}
+			if v, _ := cache.GetIFPresent("key"); v != 3 {
+				t.Errorf("%v != %v", v, 3)
+			}
+			if got, _ := cache.GetExpiration("key"); !got.Equal(expireAt) {
+				t.Errorf("%v != %v", got, expireAt)
+			}
+			if ok, _ := cache.CompareAndSwap("missing", nil, 1); ok {
+				t.Error("missing key should not be swapped")
+			}
+			fc.Advance(2 * time.Minute)
+			if ok, _ := cache.CompareAndSwap("key", 3, 4); ok {
+				t.Error("expired key should not be swapped")
+			}
+		})
+	}
+}
+
+func TestCompareAndSwapEqualFunc(t *testing.T) {
+	cache := New(8).
+		EqualFunc(func(a, b interface{}) bool {
+			return a.([]int)[0] == b.([]int)[0]
+		}).
+		Build()
+	cache.Set("key", []int{1, 2})
+	if ok, _ := cache.CompareAndSwap("key", []int{1, 3}, []int{2}); !ok {
+		t.Error("key should be swapped")
+	}
+}
+
+func TestCompareAndSwapConcurrent(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).EvictType(tp).Build()
+			cache.Set("counter", 0)
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						for {
+							v, _ := cache.GetIFPresent("counter")
+							if ok, _ := cache.CompareAndSwap("counter", v, v.(int)+1); ok {
+								break
+							}
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			if v, _ := cache.GetIFPresent("counter"); v != 1000 {
+				t.Errorf("%v != %v", v, 1000)
+			}
+		})
+	}
+}
+
+func TestPop(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var evicted []interface{}
+			cache := New(2).
+				EvictType(tp).
+				Clock(fc).
+				EvictedFunc(func(key, value interface{}) {
+					evicted = append(evicted, key)
+				}).
+				Build()
+			cache.Set("a", 1)
+			cache.SetWithExpire("b", 2, time.Minute)
+
+			if v, err := cache.Pop("a"); v != 1 || err != nil {
+				t.Errorf("%v, %v != 1, nil", v, err)
+			}
+			if _, err := cache.Pop("a"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			if !reflect.DeepEqual(evicted, []interface{}{"a"}) {
+				t.Errorf("%v != %v", evicted, []interface{}{"a"})
+			}
+
+			fc.Advance(2 * time.Minute)
+			if _, err := cache.Pop("b"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			if l := cache.Len(false); l != 0 {
+				t.Errorf("%v != %v", l, 0)
+			}
+
+			// The cache is usable up to its size after popping.
+			for i := 0; i < 3; i++ {
+				cache.Set(i, i)
+			}
+			if l := cache.Len(false); l != 2 {
+				t.Errorf("%v != %v", l, 2)
+			}
+		})
+	}
+}
+
+func TestRemoveAll(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var evicted []interface{}
+			cache := New(4).
+				EvictType(tp).
+				Clock(fc).
+				EvictedFunc(func(key, value interface{}) {
+					evicted = append(evicted, key)
+				}).
+				Build()
+			cache.Set("a", 1)
+			cache.Set("b", 2)
+			cache.SetWithExpire("expired", 3, time.Minute)
+			cache.Set("kept", 4)
+			fc.Advance(2 * time.Minute)
+
+			if n := cache.RemoveAll("a", "missing", "b", "expired"); n != 3 {
+				t.Errorf("%v != %v", n, 3)
+			}
+			sort.Slice(evicted, func(i, j int) bool { return evicted[i].(string) < evicted[j].(string) })
+			if expected := []interface{}{"a", "b", "expired"}; !reflect.DeepEqual(evicted, expected) {
+				t.Errorf("%v != %v", evicted, expected)
+			}
+			if keys := cache.Keys(false); !reflect.DeepEqual(keys, []interface{}{"kept"}) {
+				t.Errorf("%v != %v", keys, []interface{}{"kept"})
+			}
+
+			// The cache is usable up to its size after removing.
+			for i := 0; i < 5; i++ {
+				cache.Set(i, i)
+			}
+			if l := cache.Len(false); l != 4 {
+				t.Errorf("%v != %v", l, 4)
+			}
+		})
+	}
+}
+
+func TestPopConcurrent(t *testing.T) {
+	cache := New(8).LRU().Build()
+	cache.Set("job", 1)
+	var pops int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Pop("job"); err == nil {
+				atomic.AddInt32(&pops, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if pops != 1 {
+		t.Errorf("%v != %v", pops, 1)
+	}
+}
+
+func TestSwap(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).EvictType(tp).Clock(fc).Build()
+
+			old, existed, err := cache.Swap("key", 1)
+			if old != nil || existed || err != nil {
+				t.Errorf("%v, %v, %v != nil, false, nil", old, existed, err)
+			}
+			old, existed, err = cache.Swap("key", 2)
+			if old != 1 || !existed || err != nil {
+				t.Errorf("%v, %v, %v != 1, true, nil", old, existed, err)
+			}
+
+			cache.SetWithExpire("key", 3, time.Minute)
+			fc.Advance(2 * time.Minute)
+			old, existed, err = cache.Swap("key", 4)
+			if old != nil || existed || err != nil {
+				t.Errorf("%v, %v, %v != nil, false, nil", old, existed, err)
+			}
+			if v, err := cache.GetIFPresent("key"); v != 4 || err != nil {
+				t.Errorf("%v, %v != 4, nil", v, err)
+			}
+		})
+	}
+}
+
+func TestSwapKeepsLFUFrequency(t *testing.T) {
+	for _, access := range []bool{true, false} {
+		cache := New(8).LFU().SetCountsAsAccess(access).Build()
+		cache.Set("key", 1)
+		for i := 0; i < 3; i++ {
+			cache.GetIFPresent("key")
+		}
+		before := cache.(*lfuCache).FreqDistribution()
+		if !reflect.DeepEqual(before, map[uint]int{3: 1}) {
+			t.Errorf("%v != %v", before, map[uint]int{3: 1})
+		}
+		cache.Swap("key", 2)
+		after := cache.(*lfuCache).FreqDistribution()
+		if !reflect.DeepEqual(after, before) {
+			t.Errorf("%v -> %v", before, after)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).EvictType(tp).Build()
+			var wg sync.WaitGroup
+			for i := 0; i < 20; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < 50; j++ {
+						cache.Update("counter", func(old interface{}, exists bool) (interface{}, error) {
+							if !exists {
+								return 1, nil
+							}
+							return old.(int) + 1, nil
+						})
+					}
+				}()
+			}
+			wg.Wait()
+			if v, _ := cache.GetIFPresent("counter"); v != 1000 {
+				t.Errorf("%v != %v", v, 1000)
+			}
+
+			errUpdate := errors.New("update failed")
+			err := cache.Update("counter", func(old interface{}, exists bool) (interface{}, error) {
+				return 0, errUpdate
+			})
+			if err != errUpdate {
+				t.Errorf("%v != %v", err, errUpdate)
+			}
+			if v, _ := cache.GetIFPresent("counter"); v != 1000 {
+				t.Errorf("%v != %v", v, 1000)
+			}
+		})
+	}
+}
+
+func TestUpdateSerializes(t *testing.T) {
+	cache := New(8).
+		SerializeFunc(func(key, value interface{}) (interface{}, error) {
+			return fmt.Sprint(value), nil
+		}).
+		DeserializeFunc(func(key, value interface{}) (interface{}, error) {
+			var n int
+			_, err := fmt.Sscan(value.(string), &n)
+			return n, err
+		}).
+		Build()
+	cache.Set("key", 1)
+	cache.Update("key", func(old interface{}, exists bool) (interface{}, error) {
+		return old.(int) + 1, nil
+	})
+	if v, _ := cache.GetRaw("key"); v != "2" {
+		t.Errorf("%v != %v", v, "2")
+	}
+	if v, _ := cache.GetIFPresent("key"); v != 2 {
+		t.Errorf("%v != %v", v, 2)
+	}
+}
+
+func TestIncrement(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).EvictType(tp).Clock(fc).Build()
+
+			if n, err := cache.Increment("new", 5); n != 5 || err != nil {
+				t.Errorf("%v, %v != 5, nil", n, err)
+			}
+			if v, _ := cache.GetIFPresent("new"); v != int64(5) {
+				t.Errorf("%v != %v", v, int64(5))
+			}
+
+			cache.SetWithExpire("int", 10, time.Minute)
+			expireAt, _ := cache.GetExpiration("int")
+			if n, err := cache.Increment("int", 2); n != 12 || err != nil {
+				t.Errorf("%v, %v != 12, nil", n, err)
+			}
+			if n, err := cache.Decrement("int", 5); n != 7 || err != nil {
+				t.Errorf("%v, %v != 7, nil", n, err)
+			}
+			if v, _ := cache.GetIFPresent("int"); v != 7 {
+				t.Errorf("%v != %v", v, 7)
+			}
+			if got, _ := cache.GetExpiration("int"); !got.Equal(expireAt) {
+				t.Errorf("%v != %v", got, expireAt)
+			}
+
+			cache.Set("float", 1.0)
+			if n, err := cache.Increment("float", 1); n != 2 || err != nil {
+				t.Errorf("%v, %v != 2, nil", n, err)
+			}
+			if v, _ := cache.GetIFPresent("float"); v != 2.0 {
+				t.Errorf("%v != %v", v, 2.0)
+			}
+
+			cache.Set("fraction", 1.5)
+			if _, err := cache.Increment("fraction", 1); err != ErrNotANumber {
+				t.Errorf("%v != %v", err, ErrNotANumber)
+			}
+			if v, _ := cache.GetIFPresent("fraction"); v != 1.5 {
+				t.Errorf("%v != %v", v, 1.5)
+			}
+
+			cache.Set("string", "1")
+			if _, err := cache.Increment("string", 1); err != ErrNotANumber {
+				t.Errorf("%v != %v", err, ErrNotANumber)
+			}
+
+			fc.Advance(2 * time.Minute)
+			if n, err := cache.Increment("int", 1); n != 1 || err != nil {
+				t.Errorf("%v, %v != 1, nil", n, err)
+			}
+		})
+	}
+}
+
+func TestIncrementOverflow(t *testing.T) {
+	cache := New(8).Build()
+	cache.Set("int64", int64(math.MaxInt64))
+	if _, err := cache.Increment("int64", 1); err != ErrOverflow {
+		t.Errorf("%v != %v", err, ErrOverflow)
+	}
+	if v, _ := cache.GetIFPresent("int64"); v != int64(math.MaxInt64) {
+		t.Errorf("%v != %v", v, int64(math.MaxInt64))
+	}
+	cache.Set("min", int64(math.MinInt64))
+	if _, err := cache.Decrement("min", 1); err != ErrOverflow {
+		t.Errorf("%v != %v", err, ErrOverflow)
+	}
+
+	// The largest int, whether it is 32 or 64 bits wide.
+	maxInt := int(^uint(0) >> 1)
+	cache.Set("int", maxInt)
+	if _, err := cache.Increment("int", 1); err != ErrOverflow {
+		t.Errorf("%v != %v", err, ErrOverflow)
+	}
+	if v, _ := cache.GetIFPresent("int"); v != maxInt {
+		t.Errorf("%v != %v", v, maxInt)
+	}
+}
+
+func TestIncrementConcurrent(t *testing.T) {
+	cache := New(8).LRU().Build()
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				cache.Increment("counter", 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if v, _ := cache.GetIFPresent("counter"); v != int64(1000) {
+		t.Errorf("%v != %v", v, 1000)
+	}
+	if hits, misses := cache.HitCount(), cache.MissCount(); hits != 1000 || misses != 1 {
+		t.Errorf("%v, %v != 1000, 1", hits, misses)
+	}
+}
+
+func TestSetIfAbsentKeepsLRUOrder(t *testing.T) {
+	cache := New(2).LRU().Build()
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.SetIfAbsent("a", 10)
+	cache.Set("c", 3)
+	if cache.Existed("a") {
+		t.Error("a should be evicted")
+	}
+}
+
+func TestGetExpirationKeepsLRUOrder(t *testing.T) {
+	cache := New(2).LRU().Build()
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	if _, err := cache.GetExpiration("a"); err != nil {
+		t.Fatal(err)
+	}
+	cache.Set("c", 3)
+	if cache.Existed("a") {
+		t.Error("a should be evicted")
+	}
+}
+
+func TestSetWithExpireZeroAndNegative(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(time.Second).
+				Build()
+
+			if err := cache.SetWithExpire("zero", "value", 0); err != nil {
+				t.Fatal(err)
+			}
+			fc.Advance(time.Hour)
+			if v, err := cache.GetIFPresent("zero"); err != nil || v != "value" {
+				t.Errorf("zero expiration should never expire: %v, %v", v, err)
+			}
+
+			if err := cache.SetWithExpire("negative", "value", -time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := cache.GetIFPresent("negative"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+
+			cache.Set("existing", "value")
+			if err := cache.SetWithExpire("existing", "value", -time.Second); err != nil {
+				t.Fatal(err)
+			}
+			if l := cache.Len(false); l != 1 {
+				t.Errorf("%v != %v", l, 1)
+			}
+		})
+	}
+}
+
+func TestGetMultiWithExpiration(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Build()
+			now := fc.Now()
+			cache.Set(0, 0)
+			for i := 1; i < 4; i++ {
+				cache.SetWithExpire(i, i, time.Duration(i)*time.Second)
+			}
+			fc.Advance(time.Second + time.Millisecond)
+
+			entries := cache.GetMultiWithExpiration([]interface{}{0, 1, 2, 3, 4})
+			if len(entries) != 3 {
+				t.Fatalf("%v != %v", len(entries), 3)
+			}
+			if e := entries[0]; e.Value != 0 || !e.ExpireAt.IsZero() {
+				t.Errorf("unexpected entry %v", e)
+			}
+			for i := 2; i < 4; i++ {
+				e, ok := entries[i]
+				if !ok {
+					t.Fatalf("entries should contain %v", i)
+				}
+				if e.Value != i {
+					t.Errorf("%v != %v", e.Value, i)
+				}
+				if expected := now.Add(time.Duration(i) * time.Second); !e.ExpireAt.Equal(expected) {
+					t.Errorf("%v != %v", e.ExpireAt, expected)
+				}
+			}
+			if lc := cache.LookupCount(); lc != 0 {
+				t.Errorf("%v != %v", lc, 0)
+			}
+		})
+	}
+}
+
+func TestGetMultiWithExpirationMaxIdle(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				MaxIdle(2 * time.Second).
+				Build()
+			cache.Set("idle", 1)
+			cache.SetWithExpire("ttl", 1, time.Second)
+			cache.SetWithExpire("both", 1, 5*time.Second)
+
+			entries := cache.GetMultiWithExpiration([]interface{}{"idle", "ttl", "both"})
+			for key, d := range map[string]time.Duration{"idle": 2 * time.Second, "ttl": time.Second, "both": 2 * time.Second} {
+				expected := fc.Now().Add(d)
+				if e := entries[key]; !e.ExpireAt.Equal(expected) {
+					t.Errorf("%v: %v != %v", key, e.ExpireAt, expected)
+				}
+				if expireAt, _ := cache.GetExpiration(key); !expireAt.Equal(entries[key].ExpireAt) {
+					t.Errorf("%v: %v != %v", key, expireAt, entries[key].ExpireAt)
+				}
+			}
+		})
+	}
+}
+
+func TestGetMultiWithExpirationKeyCodec(t *testing.T) {
+	var keys []interface{}
+	cache := New(8).
+		KeyCodec(prefixKeyCodec{}).
+		SerializeFunc(func(k, v interface{}) (interface{}, error) {
+			return v, nil
+		}).
+		DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+			keys = append(keys, k)
+			return v, nil
+		}).
+		Build()
+	cache.Set("a", 1)
+	entries := cache.GetMultiWithExpiration([]interface{}{"a"})
+	if e, ok := entries["a"]; !ok || e.Value != 1 {
+		t.Errorf("unexpected entries %v", entries)
+	}
+	if len(keys) != 1 || keys[0] != "a" {
+		t.Errorf("%v != %v", keys, []interface{}{"a"})
+	}
+}
+
+func TestLazySerialize(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var serializeCounter, deserializeCounter int
+			var evicted, purged []interface{}
+			cache := New(2).
+				EvictType(tp).
+				LazySerialize(true).
+				SerializeFunc(func(k, v interface{}) (interface{}, error) {
+					serializeCounter++
+					return fmt.Sprintf("encoded-%v", v), nil
+				}).
+				DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+					deserializeCounter++
+					return v, nil
+				}).
+				EvictedFunc(func(k, v interface{}) {
+					evicted = append(evicted, v)
+				}).
+				PurgeVisitorFunc(func(k, v interface{}) {
+					purged = append(purged, v)
+				}).
+				Build()
+
+			cache.Set(0, 0)
+			cache.Set(1, 1)
+			if v, err := cache.GetIFPresent(1); err != nil || v != 1 {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			if serializeCounter != 0 || deserializeCounter != 0 {
+				t.Fatalf("serialized %v times, deserialized %v times", serializeCounter, deserializeCounter)
+			}
+
+			cache.Set(2, 2)
+			if serializeCounter != 1 {
+				t.Fatalf("%v != %v", serializeCounter, 1)
+			}
+			if len(evicted) != 1 || (evicted[0] != "encoded-0" && evicted[0] != "encoded-1") {
+				t.Errorf("unexpected evicted values %v", evicted)
+			}
+
+			cache.Purge()
+			if serializeCounter != 3 || len(purged) != 2 {
+				t.Errorf("serialized %v times, purged %v", serializeCounter, purged)
+			}
+			if deserializeCounter != 0 {
+				t.Errorf("%v != %v", deserializeCounter, 0)
+			}
+		})
+	}
+}
+
+func TestExpireAll(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Build()
+			cache.Set(0, 0)
+			cache.SetWithExpire(1, 1, time.Second)
+			cache.SetWithExpire(2, 2, time.Minute)
+			cache.SetWithExpire(3, 3, time.Millisecond)
+			fc.Advance(time.Second / 2)
+
+			if n := cache.ExpireAll(time.Hour); n != 3 {
+				t.Fatalf("%v != %v", n, 3)
+			}
+			deadline := fc.Now().Add(time.Hour)
+			entries := cache.GetMultiWithExpiration([]interface{}{0, 1, 2, 3})
+			if len(entries) != 3 {
+				t.Fatalf("%v != %v", len(entries), 3)
+			}
+			for k, e := range entries {
+				if !e.ExpireAt.Equal(deadline) {
+					t.Errorf("key %v: %v != %v", k, e.ExpireAt, deadline)
+				}
+			}
+
+			if n := cache.ExpireAll(0); n != 3 {
+				t.Fatalf("%v != %v", n, 3)
+			}
+			fc.Advance(2 * time.Hour)
+			if l := len(cache.GetMultiWithExpiration([]interface{}{0, 1, 2})); l != 3 {
+				t.Errorf("%v != %v", l, 3)
+			}
+
+			if n := cache.ExpireAll(-time.Second); n != 3 {
+				t.Fatalf("%v != %v", n, 3)
+			}
+			if l := len(cache.GetMultiWithExpiration([]interface{}{0, 1, 2})); l != 0 {
+				t.Errorf("%v != %v", l, 0)
+			}
+		})
+	}
+}
+
+func TestGetOrSetWithTTLFunc(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Build()
+
+			cache.Set("hit", "cached")
+			v, err := cache.GetOrSetWithTTLFunc("hit", func() (interface{}, time.Duration, error) {
+				t.Error("fn should not be called on a hit")
+				return nil, 0, nil
+			})
+			if err != nil || v != "cached" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+
+			v, err = cache.GetOrSetWithTTLFunc("miss", func() (interface{}, time.Duration, error) {
+				return "computed", time.Second, nil
+			})
+			if err != nil || v != "computed" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			if v, err := cache.GetIFPresent("miss"); err != nil || v != "computed" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			fc.Advance(2 * time.Second)
+			if _, err := cache.GetIFPresent("miss"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+
+			someErr := errors.New("some error")
+			_, err = cache.GetOrSetWithTTLFunc("error", func() (interface{}, time.Duration, error) {
+				return "ignored", time.Second, someErr
+			})
+			if err != someErr {
+				t.Errorf("%v != %v", err, someErr)
+			}
+			if cache.Existed("error") {
+				t.Error("nothing should be cached on error")
+			}
+
+			var calls int64
+			var wg sync.WaitGroup
+			for i := 0; i < 100; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					v, err := cache.GetOrSetWithTTLFunc("concurrent", func() (interface{}, time.Duration, error) {
+						atomic.AddInt64(&calls, 1)
+						time.Sleep(10 * time.Millisecond)
+						return "shared", 0, nil
+					})
+					if err != nil || v != "shared" {
+						t.Errorf("unexpected value %v, %v", v, err)
+					}
+				}()
+			}
+			wg.Wait()
+			if calls != 1 {
+				t.Errorf("%v != %v", calls, 1)
+			}
+		})
+	}
+}
+
+func TestGetOrSetWithTTLFuncPanic(t *testing.T) {
+	cache := New(8).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			return "loaded", nil
+		}).
+		Build()
+	func() {
+		defer func() {
+			if r := recover(); r != "boom" {
+				t.Errorf("%v != %v", r, "boom")
+			}
+		}()
+		cache.GetOrSetWithTTLFunc("a", func() (interface{}, time.Duration, error) {
+			panic("boom")
+		})
+	}()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v, err := cache.GetOrSetWithTTLFunc("a", func() (interface{}, time.Duration, error) {
+			close(started)
+			<-release
+			return "computed", 0, nil
+		})
+		if err != nil || v != "computed" {
+			t.Errorf("unexpected value %v, %v", v, err)
+		}
+	}()
+	<-started
+	// A load of the key does not join the call of GetOrSetWithTTLFunc.
+	got := make(chan interface{})
+	go func() {
+		v, _ := cache.Get(context.Background(), "a")
+		got <- v
+	}()
+	select {
+	case v := <-got:
+		if v != "loaded" {
+			t.Errorf("%v != %v", v, "loaded")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Get waits for GetOrSetWithTTLFunc")
+	}
+	close(release)
+	<-done
+}
+
+func TestGetOrSetWithTTLFuncWinsOverLoad(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	cache := New(8).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			close(started)
+			<-release
+			return "loaded", nil
+		}).
+		Build()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		cache.Get(defaultCtx, "a")
+	}()
+	<-started
+	v, err := cache.GetOrSetWithTTLFunc("a", func() (interface{}, time.Duration, error) {
+		return "computed", 0, nil
+	})
+	if err != nil || v != "computed" {
+		t.Errorf("unexpected value %v, %v", v, err)
+	}
+	close(release)
+	<-done
+	if v, _ := cache.GetIFPresent("a"); v != "computed" {
+		t.Errorf("%v != %v", v, "computed")
+	}
+}
+
+func TestType(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		if got := New(8).EvictType(tp).Build().Type(); got != tp {
+			t.Errorf("%v != %v", got, tp)
+		}
+		if got := New(8).EvictType(tp).LoaderFunc(loader).Build().Type(); got != tp {
+			t.Errorf("%v != %v", got, tp)
+		}
+	}
+}
+
+func TestLoaderRecursion(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var cache LoadingCache
+			cache = New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					if key == "nested" {
+						return cache.Get(ctx, "leaf")
+					}
+					if key == "leaf" {
+						return "value", nil
+					}
+					return cache.Get(ctx, key)
+				}).
+				Build()
+
+			done := make(chan error, 1)
+			go func() {
+				_, err := cache.Get(defaultCtx, "recursive")
+				done <- err
+			}()
+			select {
+			case err := <-done:
+				if err != ErrLoaderRecursion {
+					t.Errorf("%v != %v", err, ErrLoaderRecursion)
+				}
+			case <-time.After(time.Second):
+				t.Fatal("recursive loader deadlocked")
+			}
+
+			v, err := cache.Get(defaultCtx, "nested")
+			if err != nil || v != "value" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+		})
+	}
+}
+
+type prefixKeyCodec struct{}
+
+func (prefixKeyCodec) Encode(key interface{}) interface{} {
+	return "enc:" + key.(string)
+}
+
+func (prefixKeyCodec) Decode(key interface{}) interface{} {
+	return key.(string)[len("enc:"):]
+}
+
+func TestKeyCodec(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var loadedKeys, evictedKeys []interface{}
+			cache := New(2).
+				EvictType(tp).
+				KeyCodec(prefixKeyCodec{}).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					loadedKeys = append(loadedKeys, key)
+					return "loaded", nil
+				}).
+				EvictedFunc(func(key, value interface{}) {
+					evictedKeys = append(evictedKeys, key)
+				}).
+				Build()
+
+			cache.Set("a", 1)
+			if v, err := cache.Get(defaultCtx, "a"); err != nil || v != 1 {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			if v, err := cache.Get(defaultCtx, "b"); err != nil || v != "loaded" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			if len(loadedKeys) != 1 || loadedKeys[0] != "b" {
+				t.Errorf("loader got keys %v", loadedKeys)
+			}
+			if !cache.Existed("a") || cache.peek("enc:a") == nil || cache.peek("a") != nil {
+				t.Error("a should be stored under its encoded key")
+			}
+			keys := keysToMap(cache.Keys(false))
+			if _, ok := keys["a"]; !ok || len(keys) != 2 {
+				t.Errorf("unexpected keys %v", keys)
+			}
+			if _, ok := cache.GetALL(false)["b"]; !ok {
+				t.Error("GetALL should return decoded keys")
+			}
+			if !cache.Remove("a") {
+				t.Error("a should be removed")
+			}
+			if len(evictedKeys) != 1 || evictedKeys[0] != "a" {
+				t.Errorf("evictedFunc got keys %v", evictedKeys)
+			}
+		})
+	}
+}
+
+func TestCacheLoaderErrors(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			someErr := errors.New("some error")
+			fc := NewFakeClock()
+			var loaderCounter int
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				CacheLoaderErrors(time.Second).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					loaderCounter++
+					return nil, fmt.Errorf("load %v: %w", key, someErr)
+				}).
+				Build()
+
+			for i := 0; i < 3; i++ {
+				if _, err := cache.Get(defaultCtx, "key"); !errors.Is(err, someErr) {
+					t.Errorf("%v is not %v", err, someErr)
+				}
+			}
+			if loaderCounter != 1 {
+				t.Errorf("%v != %v", loaderCounter, 1)
+			}
+
+			fc.Advance(2 * time.Second)
+			if _, err := cache.Get(defaultCtx, "key"); !errors.Is(err, someErr) {
+				t.Errorf("%v is not %v", err, someErr)
+			}
+			if loaderCounter != 2 {
+				t.Errorf("%v != %v", loaderCounter, 2)
+			}
+		})
+	}
+}
+
+func TestCacheLoaderErrorsBounded(t *testing.T) {
+	fc := NewFakeClock()
+	loads := 0
+	cache := New(8).
+		LRU().
+		Clock(fc).
+		CacheLoaderErrors(time.Second).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			loads++
+			return nil, errors.New("some error")
+		}).
+		Build()
+	c := cache.(*lruCache)
+	for i := 0; i < 10000; i++ {
+		cache.Get(defaultCtx, i)
+		fc.Advance(time.Millisecond)
+	}
+	// Only the errors of the last second are live.
+	if n := len(c.loaderErrors); n > 2*1000+minLoaderErrorsSweep {
+		t.Errorf("%v loader errors are kept", n)
+	}
+
+	cache.Get(defaultCtx, "key")
+	cache.Purge()
+	if n := len(c.loaderErrors); n != 0 {
+		t.Errorf("%v != %v", n, 0)
+	}
+	loads = 0
+	cache.Get(defaultCtx, "key")
+	if loads != 1 {
+		t.Errorf("%v != %v", loads, 1)
+	}
+}
+
+func TestCacheLoaderErrorsConcurrentWaiters(t *testing.T) {
+	someErr := errors.New("some error")
+	fc := NewFakeClock()
+	var loaderCounter int32
+	release := make(chan struct{})
+	cache := New(8).
+		Clock(fc).
+		CacheLoaderErrors(time.Second).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			atomic.AddInt32(&loaderCounter, 1)
+			<-release
+			return nil, someErr
+		}).
+		Build()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := cache.Get(defaultCtx, "key"); err != someErr {
+				t.Errorf("%v != %v", err, someErr)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&loaderCounter) == 0 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	// Retries within the window get the error without calling the loader.
+	fc.Advance(500 * time.Millisecond)
+	if _, err := cache.Get(defaultCtx, "key"); err != someErr {
+		t.Errorf("%v != %v", err, someErr)
+	}
+	if n := atomic.LoadInt32(&loaderCounter); n != 1 {
+		t.Errorf("%v != %v", n, 1)
+	}
+
+	fc.Advance(time.Second)
+	cache.Get(defaultCtx, "key")
+	if n := atomic.LoadInt32(&loaderCounter); n != 2 {
+		t.Errorf("%v != %v", n, 2)
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				SerializeFunc(func(k, v interface{}) (interface{}, error) {
+					return []interface{}{v}, nil
+				}).
+				DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+					return v.([]interface{})[0], nil
+				}).
+				Build()
+			setItemsByRange(t, cache, 0, 4)
+			cache.SetWithExpire(4, 4, time.Second)
+			fc.Advance(2 * time.Second)
+
+			snapshot := cache.Snapshot()
+			if len(snapshot) != 4 {
+				t.Fatalf("%v != %v", len(snapshot), 4)
+			}
+			for i := 0; i < 4; i++ {
+				if snapshot[i] != i {
+					t.Errorf("%v != %v", snapshot[i], i)
+				}
+			}
+		})
+	}
+}
+
+func TestCopyOnExport(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	copySlice := func(v interface{}) interface{} {
+		return append([]int(nil), v.([]int)...)
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				CopyOnExport(copySlice).
+				Build()
+			cache.Set("a", []int{1, 2, 3})
+
+			cache.GetALL(true)["a"].([]int)[0] = 10
+			cache.Snapshot()["a"].([]int)[1] = 20
+
+			v, err := cache.GetIFPresent("a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := []int{1, 2, 3}; fmt.Sprint(v) != fmt.Sprint(expected) {
+				t.Errorf("%v != %v", v, expected)
+			}
+		})
+	}
+}
+
+func TestCopyOnExportAfterDeserialize(t *testing.T) {
+	cache := New(8).
+		LRU().
+		SerializeFunc(func(k, v interface{}) (interface{}, error) {
+			return fmt.Sprint(v), nil
+		}).
+		DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+			return []string{v.(string)}, nil
+		}).
+		CopyOnExport(func(v interface{}) interface{} {
+			return append([]string(nil), v.([]string)...)
+		}).
+		Build()
+	cache.Set("a", 1)
+	v := cache.Snapshot()["a"]
+	if expected := []string{"1"}; fmt.Sprint(v) != fmt.Sprint(expected) {
+		t.Errorf("%v != %v", v, expected)
+	}
+}
+
+func benchmarkWritersDuringExport(b *testing.B, export func(Cache)) {
+	size := 10000
+	cache := New(size).
+		LRU().
+		DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+			time.Sleep(time.Microsecond)
+			return v, nil
+		}).
+		Build()
+	for i := 0; i < size; i++ {
+		cache.Set(i, i)
+	}
+
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+				export(cache)
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cache.Set(i%size, i)
+	}
+	b.StopTimer()
+	close(done)
+	wg.Wait()
+}
+
+func BenchmarkSetDuringGetALL(b *testing.B) {
+	benchmarkWritersDuringExport(b, func(c Cache) { c.GetALL(true) })
+}
+
+func BenchmarkSetDuringSnapshot(b *testing.B) {
+	benchmarkWritersDuringExport(b, func(c Cache) { c.Snapshot() })
+}
+
+func TestSetAll(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var added int
+			cache := New(4).
+				EvictType(tp).
+				Clock(fc).
+				AddedFunc(func(key, value interface{}) {
+					added++
+				}).
+				Build()
+			entries := map[interface{}]interface{}{"a": 1, "b": 2, "c": 3}
+			if err := cache.SetAll(entries); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(cache.GetALL(true), entries) {
+				t.Errorf("%v != %v", cache.GetALL(true), entries)
+			}
+			if added != 3 {
+				t.Errorf("%v != %v", added, 3)
+			}
+
+			if err := cache.SetAllWithExpire(map[interface{}]interface{}{"d": 4, "e": 5}, time.Minute); err != nil {
+				t.Fatal(err)
+			}
+			if l := cache.Len(false); l != 4 {
+				t.Errorf("%v != %v", l, 4)
+			}
+			fc.Advance(2 * time.Minute)
+			for _, key := range []string{"d", "e"} {
+				if _, err := cache.GetIFPresent(key); err != ErrKeyNotFound {
+					t.Errorf("%v: %v != %v", key, err, ErrKeyNotFound)
+				}
+			}
+		})
+	}
+}
+
+func TestSetAllSerializeError(t *testing.T) {
+	errSerialize := errors.New("cannot serialize")
+	cache := New(8).
+		SerializeFunc(func(key, value interface{}) (interface{}, error) {
+			if key == "bad" {
+				return nil, errSerialize
+			}
+			return value, nil
+		}).
+		Build()
+	err := cache.SetAll(map[interface{}]interface{}{"bad": 1})
+	me, ok := err.(*MultiError)
+	if !ok {
+		t.Fatalf("%T is not a *MultiError", err)
+	}
+	if errs := me.Errors(); len(errs) != 1 || errs["bad"] != errSerialize {
+		t.Errorf("%v != map[bad:%v]", errs, errSerialize)
+	}
+	if cache.Existed("bad") {
+		t.Error("bad should not be set")
+	}
+}
+
+func benchmarkSetAll(b *testing.B, tp string, setAll bool) {
+	const n = 1000
+	entries := make(map[interface{}]interface{}, n)
+	for i := 0; i < n; i++ {
+		entries[i] = i
+	}
+	cache := New(n).EvictType(tp).Build()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if setAll {
+			cache.SetAll(entries)
+			continue
+		}
+		for k, v := range entries {
+			cache.Set(k, v)
+		}
+	}
+}
+
+func BenchmarkSetAll(b *testing.B) {
+	for _, tp := range []string{TypeLru, TypeLfu} {
+		b.Run(tp+"/Set", func(b *testing.B) { benchmarkSetAll(b, tp, false) })
+		b.Run(tp+"/SetAll", func(b *testing.B) { benchmarkSetAll(b, tp, true) })
+	}
+}
+
+func TestGetWithLoader(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					return "default", nil
+				}).
+				Build()
+
+			var overrideCounter int
+			override := func(ctx context.Context, key interface{}) (interface{}, *time.Duration, error) {
+				overrideCounter++
+				return "override", nil, nil
+			}
+			for i := 0; i < 2; i++ {
+				v, err := cache.GetWithLoader(defaultCtx, "a", override)
+				if err != nil || v != "override" {
+					t.Errorf("unexpected value %v, %v", v, err)
+				}
+			}
+			if overrideCounter != 1 {
+				t.Errorf("%v != %v", overrideCounter, 1)
+			}
+			if v, err := cache.Get(defaultCtx, "a"); err != nil || v != "override" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+
+			v, err := cache.GetWithLoader(defaultCtx, "b", nil)
+			if err != nil || v != "default" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+		})
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).EvictType(tp).Build()
+			cache.Set("a", 0)
+			cache.Set("b", 0)
+
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					cache.Transaction(func(tx Tx) error {
+						a, _ := tx.Get("a")
+						b, _ := tx.Get("b")
+						if a != b {
+							t.Errorf("%v != %v", a, b)
+						}
+						return nil
+					})
+					entries := cache.GetMultiWithExpiration([]interface{}{"a", "b"})
+					if entries["a"].Value != entries["b"].Value {
+						t.Errorf("%v != %v", entries["a"].Value, entries["b"].Value)
+					}
+				}
+			}()
+			for i := 1; i <= 100; i++ {
+				err := cache.Transaction(func(tx Tx) error {
+					tx.Set("a", i)
+					if v, _ := tx.Get("a"); v != i {
+						t.Errorf("%v != %v", v, i)
+					}
+					return tx.Set("b", i)
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+			close(stop)
+			wg.Wait()
+
+			fail := errors.New("fail")
+			err := cache.Transaction(func(tx Tx) error {
+				tx.Set("a", -1)
+				tx.Remove("b")
+				return fail
+			})
+			if err != fail {
+				t.Errorf("%v != %v", err, fail)
+			}
+			if v, _ := cache.GetIFPresent("a"); v != 100 {
+				t.Errorf("%v != %v", v, 100)
+			}
+			if v, _ := cache.GetIFPresent("b"); v != 100 {
+				t.Errorf("%v != %v", v, 100)
+			}
+
+			cache.Transaction(func(tx Tx) error {
+				if !tx.Remove("a") {
+					t.Error("Remove should report a present key")
+				}
+				if _, err := tx.Get("a"); err != ErrKeyNotFound {
+					t.Errorf("%v != %v", err, ErrKeyNotFound)
+				}
+				return nil
+			})
+			if cache.Existed("a") {
+				t.Error("a should be removed")
+			}
+		})
+	}
+}
+
+func TestTransactionSerializeError(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	fail := errors.New("fail")
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				SerializeFunc(func(k, v interface{}) (interface{}, error) {
+					if v == -1 {
+						return nil, fail
+					}
+					return v, nil
+				}).
+				Build()
+			cache.Set("a", 0)
+			cache.Set("b", 0)
+			err := cache.Transaction(func(tx Tx) error {
+				tx.Set("a", 1)
+				tx.Remove("b")
+				tx.Set("c", -1)
+				return nil
+			})
+			if err != fail {
+				t.Errorf("%v != %v", err, fail)
+			}
+			if v, _ := cache.GetIFPresent("a"); v != 0 {
+				t.Errorf("%v != %v", v, 0)
+			}
+			if !cache.Existed("b") {
+				t.Error("b should not be removed")
+			}
+		})
+	}
+}
+
+func TestSkipUnchangedSet(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var added int
+			cache := New(8).
+				EvictType(tp).
+				SkipUnchangedSet(true).
+				AddedFunc(func(key, value interface{}) {
+					added++
+				}).
+				Build()
+
+			cache.Set("a", []int{1})
+			cache.Set("a", []int{1})
+			if added != 1 {
+				t.Errorf("%v != %v", added, 1)
+			}
+			cache.Set("a", []int{2})
+			if added != 2 {
+				t.Errorf("%v != %v", added, 2)
+			}
+			cache.Remove("a")
+			cache.Set("a", []int{2})
+			if added != 3 {
+				t.Errorf("%v != %v", added, 3)
+			}
+		})
+	}
+
+	t.Run("EqualFunc", func(t *testing.T) {
+		var added int
+		cache := New(8).
+			SkipUnchangedSet(true).
+			EqualFunc(func(a, b interface{}) bool {
+				return a.(string)[0] == b.(string)[0]
+			}).
+			AddedFunc(func(key, value interface{}) {
+				added++
+			}).
+			Build()
+		cache.Set("a", "foo")
+		cache.Set("a", "far")
+		if added != 1 {
+			t.Errorf("%v != %v", added, 1)
+		}
+		if v, _ := cache.GetIFPresent("a"); v != "foo" {
+			t.Errorf("%v != %v", v, "foo")
+		}
+	})
+}
+
+func TestDropLoadIfRemoved(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			cache := New(8).
+				EvictType(tp).
+				DropLoadIfRemoved(true).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					if key == "slow" {
+						close(started)
+						<-release
+					}
+					return "loaded", nil
+				}).
+				Build()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				v, err := cache.Get(defaultCtx, "slow")
+				if err != nil || v != "loaded" {
+					t.Errorf("unexpected value %v, %v", v, err)
+				}
+			}()
+			<-started
+			cache.Remove("slow")
+			close(release)
+			<-done
+
+			if cache.Existed("slow") {
+				t.Error("removed key should not be stored by the load")
+			}
+
+			// Loads which are not interrupted by a removal are stored.
+			cache.Get(defaultCtx, "fast")
+			if !cache.Existed("fast") {
+				t.Error("loaded key should be stored")
+			}
+		})
+	}
+}
+
+func TestForceRefresh(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var counter int
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					counter++
+					return counter, nil
+				}).
+				Build()
+
+			v, old, existed, err := cache.ForceRefresh(defaultCtx, "a")
+			if err != nil || v != 1 || old != nil || existed {
+				t.Errorf("unexpected result %v, %v, %v, %v", v, old, existed, err)
+			}
+			v, old, existed, err = cache.ForceRefresh(defaultCtx, "a")
+			if err != nil || v != 2 || old != 1 || !existed {
+				t.Errorf("unexpected result %v, %v, %v, %v", v, old, existed, err)
+			}
+			if v, _ := cache.Get(defaultCtx, "a"); v != 2 {
+				t.Errorf("%v != %v", v, 2)
+			}
+			if counter != 2 {
+				t.Errorf("%v != %v", counter, 2)
+			}
+		})
+	}
+}
+
+func TestExplicitSetWinsOverLoad(t *testing.T) {
+	for _, wins := range []bool{true, false} {
+		t.Run(fmt.Sprint(wins), func(t *testing.T) {
+			started := make(chan struct{})
+			release := make(chan struct{})
+			cache := New(8).
+				ExplicitSetWinsOverLoad(wins).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					close(started)
+					<-release
+					return "loaded", nil
+				}).
+				Build()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				v, err := cache.Get(defaultCtx, "a")
+				if err != nil || v != "loaded" {
+					t.Errorf("unexpected value %v, %v", v, err)
+				}
+			}()
+			<-started
+			cache.Set("a", "set")
+			close(release)
+			<-done
+
+			expected := "set"
+			if !wins {
+				expected = "loaded"
+			}
+			if v, _ := cache.GetIFPresent("a"); v != expected {
+				t.Errorf("%v != %v", v, expected)
+			}
+		})
+	}
+}
+
+func TestWatchedLoaderPanic(t *testing.T) {
+	cache := New(8).
+		DropLoadIfRemoved(true).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			panic("boom")
+		}).
+		Build()
+	if _, err := cache.Get(defaultCtx, "a"); err == nil {
+		t.Error("expected an error from the panicking loader")
+	}
+	c := cache.(*simpleCache)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.loadsInFlight) != 0 {
+		t.Errorf("%v != %v", len(c.loadsInFlight), 0)
+	}
+}
+
+func TestSetWithPolicy(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(10 * time.Second).
+				MaxIdle(3 * time.Second).
+				Build()
+
+			// ttl wins: the item is read often enough to never idle out.
+			cache.SetWithPolicy("ttl", 1, 4*time.Second, 2*time.Second)
+			// tti wins: the item is never read.
+			cache.SetWithPolicy("tti", 1, 8*time.Second, 2*time.Second)
+			// Both are inherited from the builder.
+			cache.SetWithPolicy("default", 1, 0, 0)
+
+			for i := 0; i < 3; i++ {
+				fc.Advance(time.Second + time.Millisecond)
+				if _, err := cache.GetIFPresent("ttl"); err != nil {
+					t.Fatalf("ttl: %v", err)
+				}
+				if i == 1 {
+					if _, err := cache.GetIFPresent("default"); err != nil {
+						t.Fatalf("default: %v", err)
+					}
+				}
+			}
+			if _, err := cache.GetIFPresent("tti"); err != ErrKeyNotFound {
+				t.Errorf("tti: %v != %v", err, ErrKeyNotFound)
+			}
+			fc.Advance(time.Second)
+			if _, err := cache.GetIFPresent("ttl"); err != ErrKeyNotFound {
+				t.Errorf("ttl: %v != %v", err, ErrKeyNotFound)
+			}
+			// Read at 2s, so idle since then for just over 2s.
+			if _, err := cache.GetIFPresent("default"); err != nil {
+				t.Errorf("default: %v", err)
+			}
+			fc.Advance(3*time.Second + time.Millisecond)
+			if _, err := cache.GetIFPresent("default"); err != ErrKeyNotFound {
+				t.Errorf("default: %v != %v", err, ErrKeyNotFound)
+			}
+		})
+	}
+}
+
+// expirationStep runs op, if any, advances the clock and then checks that
+// key holds want, or is absent if want is nil.
+type expirationStep struct {
+	op      func(c Cache)
+	advance time.Duration
+	key     string
+	want    interface{}
+}
+
+// TestExpirationConformance runs the same expiration scenarios against every
+// cache type. Items are observed through GetIFPresent and GetMultiWithExpiration;
+// Existed, Keys, Len and GetALL still compare against the wall clock rather
+// than the injected one, so they cannot be checked with a fake clock yet.
+func TestExpirationConformance(t *testing.T) {
+	set := func(key string, value interface{}) func(Cache) {
+		return func(c Cache) { c.Set(key, value) }
+	}
+	setWithExpire := func(key string, value interface{}, d time.Duration) func(Cache) {
+		return func(c Cache) { c.SetWithExpire(key, value, d) }
+	}
+	get := func(key string) func(Cache) {
+		return func(c Cache) { c.GetIFPresent(key) }
+	}
+
+	var scenarios = []struct {
+		name    string
+		builder func(cb *CacheBuilder) *CacheBuilder
+		steps   []expirationStep
+	}{
+		{
+			name:    "builder expiration",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.Expiration(time.Second) },
+			steps: []expirationStep{
+				{op: set("k", 1), advance: time.Second, key: "k", want: 1},
+				{advance: time.Millisecond, key: "k", want: nil},
+			},
+		},
+		{
+			name:    "set with expire overrides builder expiration",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.Expiration(time.Second) },
+			steps: []expirationStep{
+				{op: setWithExpire("k", 1, 2*time.Second), advance: 1500 * time.Millisecond, key: "k", want: 1},
+				{advance: time.Second, key: "k", want: nil},
+			},
+		},
+		{
+			name:    "zero duration never expires",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.Expiration(time.Second) },
+			steps: []expirationStep{
+				{op: setWithExpire("k", 1, 0), advance: time.Hour, key: "k", want: 1},
+			},
+		},
+		{
+			name: "negative duration removes",
+			steps: []expirationStep{
+				{op: set("k", 1), key: "k", want: 1},
+				{op: setWithExpire("k", 2, -time.Second), key: "k", want: nil},
+			},
+		},
+		{
+			name: "set keeps the expiration of an existing item",
+			steps: []expirationStep{
+				{op: setWithExpire("k", 1, time.Second), key: "k", want: 1},
+				{op: set("k", 2), advance: 500 * time.Millisecond, key: "k", want: 2},
+				{advance: time.Second, key: "k", want: nil},
+			},
+		},
+		{
+			name:    "set resets builder expiration",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.Expiration(time.Second) },
+			steps: []expirationStep{
+				{op: set("k", 1), advance: 800 * time.Millisecond, key: "k", want: 1},
+				{op: set("k", 2), advance: 800 * time.Millisecond, key: "k", want: 2},
+				{advance: 300 * time.Millisecond, key: "k", want: nil},
+			},
+		},
+		{
+			name:    "expired item is revived by set",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.Expiration(time.Second) },
+			steps: []expirationStep{
+				{op: set("k", 1), advance: 2 * time.Second, key: "k", want: nil},
+				{op: set("k", 2), advance: 500 * time.Millisecond, key: "k", want: 2},
+			},
+		},
+		{
+			name:    "sliding expiration",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.MaxIdle(time.Second) },
+			steps: []expirationStep{
+				{op: set("k", 1), advance: 800 * time.Millisecond},
+				{op: get("k"), advance: 800 * time.Millisecond},
+				{op: get("k"), advance: 800 * time.Millisecond, key: "k", want: 1},
+				{advance: 1100 * time.Millisecond, key: "k", want: nil},
+			},
+		},
+		{
+			name: "loader expiration overrides builder expiration",
+			builder: func(cb *CacheBuilder) *CacheBuilder {
+				return cb.Expiration(time.Hour).LoaderExpireFunc(func(ctx context.Context, key interface{}) (interface{}, *time.Duration, error) {
+					d := time.Second
+					return 1, &d, nil
+				}).CacheBuilder
+			},
+			steps: []expirationStep{
+				{op: func(c Cache) { c.(LoadingCache).Get(defaultCtx, "k") }, advance: time.Second, key: "k", want: 1},
+				{advance: time.Millisecond, key: "k", want: nil},
+			},
+		},
+		{
+			name:    "expire all",
+			builder: func(cb *CacheBuilder) *CacheBuilder { return cb.Expiration(time.Second) },
+			steps: []expirationStep{
+				{op: set("k", 1)},
+				{op: func(c Cache) { c.ExpireAll(time.Hour) }, advance: time.Minute, key: "k", want: 1},
+				{op: func(c Cache) { c.ExpireAll(-time.Second) }, key: "k", want: nil},
+			},
+		},
+	}
+
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, sc := range scenarios {
+		t.Run(sc.name, func(t *testing.T) {
+			for _, tp := range tps {
+				fc := NewFakeClock()
+				cb := New(8).EvictType(tp).Clock(fc)
+				if sc.builder != nil {
+					cb = sc.builder(cb)
+				}
+				cache := cb.Build()
+				for i, step := range sc.steps {
+					if step.op != nil {
+						step.op(cache)
+					}
+					fc.Advance(step.advance)
+					if step.key == "" {
+						continue
+					}
+					entries := cache.GetMultiWithExpiration([]interface{}{step.key})
+					v, err := cache.GetIFPresent(step.key)
+					if step.want == nil {
+						if err != ErrKeyNotFound || len(entries) != 0 {
+							t.Errorf("%v: step %d: %v should be absent, got %v, %v", tp, i, step.key, v, err)
+						}
+						continue
+					}
+					if err != nil || v != step.want || entries[step.key].Value != step.want {
+						t.Errorf("%v: step %d: %v != %v (%v)", tp, i, v, step.want, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestGetWithDefault(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	errLoad := errors.New("load failed")
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					if key == "fail" {
+						return nil, errLoad
+					}
+					return "loaded", nil
+				}).
+				Build()
+			cache.Set("hit", "cached")
+
+			if v := cache.GetWithDefault(defaultCtx, "hit", "default"); v != "cached" {
+				t.Errorf("%v != %v", v, "cached")
+			}
+			if v := cache.GetWithDefault(defaultCtx, "miss", "default"); v != "loaded" {
+				t.Errorf("%v != %v", v, "loaded")
+			}
+			if v := cache.GetWithDefault(defaultCtx, "fail", "default"); v != "default" {
+				t.Errorf("%v != %v", v, "default")
+			}
+			if cache.Existed("fail") {
+				t.Error("the default value should not be cached")
+			}
+		})
+	}
+}
+
+func TestValues(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				SerializeFunc(func(k, v interface{}) (interface{}, error) {
+					return []interface{}{v}, nil
+				}).
+				DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+					return v.([]interface{})[0], nil
+				}).
+				Build()
+			for i := 0; i < 3; i++ {
+				cache.Set(i, i)
+			}
+			cache.SetWithExpire(3, 3, time.Second)
+			fc.Advance(2 * time.Second)
+
+			for _, checkExpired := range []bool{true, false} {
+				values := cache.Values(checkExpired)
+				sort.Slice(values, func(i, j int) bool { return values[i].(int) < values[j].(int) })
+				n := 4
+				if checkExpired {
+					n = 3
+				}
+				if len(values) != n {
+					t.Fatalf("checkExpired=%v: %v != %v", checkExpired, len(values), n)
+				}
+				for i, v := range values {
+					if v != i {
+						t.Errorf("checkExpired=%v: %v != %v", checkExpired, v, i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestEvictExpiredFirst(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var evicted []interface{}
+			cache := New(3).
+				EvictType(tp).
+				Clock(fc).
+				EvictedFunc(func(key, value interface{}) {
+					evicted = append(evicted, key)
+				}).
+				Build()
+			cache.Set("hot1", 1)
+			cache.Set("hot2", 2)
+			cache.SetWithExpire("expired", 3, time.Second)
+			cache.GetIFPresent("expired")
+			cache.GetIFPresent("hot1")
+			fc.Advance(2 * time.Second)
+
+			cache.Set("cold", 4)
+			if len(evicted) != 1 || evicted[0] != "expired" {
+				t.Errorf("%v != [expired]", evicted)
+			}
+			for _, key := range []string{"hot1", "hot2", "cold"} {
+				if _, err := cache.GetIFPresent(key); err != nil {
+					t.Errorf("%v: %v", key, err)
+				}
+			}
+		})
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		fc := NewFakeClock()
+		cache := New(3).LRU().Clock(fc).EvictExpiredFirst(false).Build()
+		cache.Set("hot1", 1)
+		cache.Set("hot2", 2)
+		cache.SetWithExpire("expired", 3, time.Second)
+		cache.GetIFPresent("expired")
+		cache.GetIFPresent("hot1")
+		fc.Advance(2 * time.Second)
+
+		cache.Set("cold", 4)
+		if _, err := cache.GetIFPresent("hot2"); err != ErrKeyNotFound {
+			t.Errorf("%v != %v", err, ErrKeyNotFound)
+		}
+	})
+}
+
+func TestMustNew(t *testing.T) {
+	var cases = []struct {
+		cache   Cache
+		tp      string
+		loading bool
+	}{
+		{MustNewLRU(8), TypeLru, false},
+		{MustNewLFU(8), TypeLfu, false},
+		{MustNewARC(8), TypeArc, false},
+		{MustNewLoadingLRU(8, getter), TypeLru, true},
+		{MustNewLoadingLFU(8, getter), TypeLfu, true},
+		{MustNewLoadingARC(8, getter), TypeArc, true},
+	}
+	for _, cs := range cases {
+		if tp := cs.cache.Type(); tp != cs.tp {
+			t.Errorf("%v != %v", tp, cs.tp)
+		}
+		cs.cache.Set("key", "value")
+		if v, err := cs.cache.GetIFPresent("key"); err != nil || v != "value" {
+			t.Errorf("%v: unexpected value %v, %v", cs.tp, v, err)
+		}
+		if cs.loading {
+			if v, err := cs.cache.(LoadingCache).Get(defaultCtx, "other"); err != nil || v != "other" {
+				t.Errorf("%v: unexpected value %v, %v", cs.tp, v, err)
+			}
+		}
+	}
+
+	for _, fn := range []func(){
+		func() { MustNewLRU(0) },
+		func() { MustNewLoadingARC(8, nil) },
+	} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("should panic on misconfiguration")
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+func TestByPrefix(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var evicted int
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				EvictedFunc(func(key, value interface{}) {
+					evicted++
+				}).
+				Build()
+			cache.Set("user:org1:a", 1)
+			cache.Set("user:org1:b", 2)
+			cache.SetWithExpire("user:org1:c", 3, time.Second)
+			cache.Set("user:org2:a", 4)
+			cache.Set(42, 5)
+			fc.Advance(2 * time.Second)
+
+			items := cache.GetAllByPrefix("user:org1:", true)
+			if len(items) != 2 || items["user:org1:a"] != 1 || items["user:org1:b"] != 2 {
+				t.Errorf("unexpected items %v", items)
+			}
+			if items := cache.GetAllByPrefix("user:org1:", false); len(items) != 3 {
+				t.Errorf("%v != %v", len(items), 3)
+			}
+			if items := cache.GetAllByPrefix("", true); len(items) != 3 {
+				t.Errorf("%v != %v", len(items), 3)
+			}
+
+			if n := cache.RemoveByPrefix("user:org1:"); n != 3 {
+				t.Errorf("%v != %v", n, 3)
+			}
+			if evicted != 3 {
+				t.Errorf("%v != %v", evicted, 3)
+			}
+			if n := cache.RemoveByPrefix("user:org1:"); n != 0 {
+				t.Errorf("%v != %v", n, 0)
+			}
+			for _, key := range []interface{}{"user:org2:a", 42} {
+				if _, err := cache.GetIFPresent(key); err != nil {
+					t.Errorf("%v: %v", key, err)
+				}
+			}
+		})
+	}
+}
+
+func TestRemoveIf(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	type versioned struct {
+		Version int
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				SerializeFunc(func(key, value interface{}) (interface{}, error) {
+					return value.(versioned).Version, nil
+				}).
+				DeserializeFunc(func(key, value interface{}) (interface{}, error) {
+					return versioned{Version: value.(int)}, nil
+				}).
+				Build()
+			for i := 0; i < 6; i++ {
+				cache.Set(i, versioned{Version: i})
+			}
+
+			n := cache.RemoveIf(func(key, value interface{}) bool {
+				return value.(versioned).Version < 4
+			})
+			if n != 4 {
+				t.Errorf("%v != %v", n, 4)
+			}
+			keys := cache.Keys(false)
+			sort.Slice(keys, func(i, j int) bool { return keys[i].(int) < keys[j].(int) })
+			if expected := []interface{}{4, 5}; !reflect.DeepEqual(keys, expected) {
+				t.Errorf("%v != %v", keys, expected)
+			}
+
+			// The cache is usable up to its size after removing.
+			for i := 10; i < 20; i++ {
+				cache.Set(i, versioned{Version: i})
+			}
+			if l := cache.Len(false); l != 8 {
+				t.Errorf("%v != %v", l, 8)
+			}
+		})
+	}
+}
+
+func TestWarmKeys(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	keys := []interface{}{"a", "b", "c", "d"}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var loaderCounter int32
+			cache, err := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					atomic.AddInt32(&loaderCounter, 1)
+					return key, nil
+				}).
+				WarmKeys(defaultCtx, keys, 2).
+				BuildE()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, key := range keys {
+				if v, err := cache.GetIFPresent(key); err != nil || v != key {
+					t.Errorf("unexpected value %v, %v", v, err)
+				}
+			}
+			if n := atomic.LoadInt32(&loaderCounter); n != int32(len(keys)) {
+				t.Errorf("%v != %v", n, len(keys))
+			}
+		})
+	}
+
+	t.Run("error", func(t *testing.T) {
+		errLoad := errors.New("load failed")
+		_, err := New(8).
+			LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+				if key == "c" {
+					return nil, errLoad
+				}
+				return key, nil
+			}).
+			WarmKeys(defaultCtx, keys, 2).
+			BuildE()
+		if !errors.Is(err, errLoad) {
+			t.Fatalf("%v is not %v", err, errLoad)
+		}
+		var me *MultiError
+		if !errors.As(err, &me) || len(me.Errors()) != 1 {
+			t.Errorf("%v should hold the error of c only", err)
+		}
+	})
+}
+
+func TestEnforceKeyType(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				EnforceKeyType("").
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					return key, nil
+				}).
+				Build()
+
+			if err := cache.Set("1", 1); err != nil {
+				t.Error(err)
+			}
+			if v, err := cache.Get(defaultCtx, "1"); err != nil || v != 1 {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+
+			if err := cache.Set(1, 1); !errors.Is(err, ErrKeyTypeMismatch) {
+				t.Errorf("%v is not %v", err, ErrKeyTypeMismatch)
+			}
+			if _, err := cache.Get(defaultCtx, 1); !errors.Is(err, ErrKeyTypeMismatch) {
+				t.Errorf("%v is not %v", err, ErrKeyTypeMismatch)
+			}
+			if _, err := cache.GetIFPresent(1); !errors.Is(err, ErrKeyTypeMismatch) {
+				t.Errorf("%v is not %v", err, ErrKeyTypeMismatch)
+			}
+			if n := cache.Len(false); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+		})
+	}
+}
+
+func TestGetNilContext(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			logger := &recordingLogger{}
+			cache := New(8).
+				EvictType(tp).
+				Logger(logger).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					if ctx == nil {
+						t.Error("the loader should receive a non-nil context")
+						return nil, errors.New("nil context")
+					}
+					select {
+					case <-ctx.Done():
+						return nil, ctx.Err()
+					default:
+						return key, nil
+					}
+				}).
+				Build()
+
+			if v, err := cache.Get(nil, "a"); err != nil || v != "a" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			if n := len(logger.msgs); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+		})
+	}
+}
+
+func TestVersion(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					return key, nil
+				}).
+				Build()
+
+			if _, ok := cache.Version("a"); ok {
+				t.Error("a missing key should have no version")
+			}
+			cache.Set("a", 1)
+			v1, ok := cache.Version("a")
+			if !ok {
+				t.Fatal("a should have a version")
+			}
+			cache.GetIFPresent("a")
+			if v, _ := cache.Version("a"); v != v1 {
+				t.Errorf("%v != %v", v, v1)
+			}
+			cache.Set("a", 2)
+			v2, _ := cache.Version("a")
+			if v2 <= v1 {
+				t.Errorf("version %v should be greater than %v", v2, v1)
+			}
+			cache.ForceRefresh(defaultCtx, "a")
+			v3, _ := cache.Version("a")
+			if v3 <= v2 {
+				t.Errorf("version %v should be greater than %v", v3, v2)
+			}
+			if e := cache.GetMultiWithExpiration([]interface{}{"a"})["a"]; e.Version != v3 {
+				t.Errorf("%v != %v", e.Version, v3)
+			}
+
+			// A removed and re-added key does not get an old version back.
+			cache.Remove("a")
+			cache.Set("a", 3)
+			if v, _ := cache.Version("a"); v <= v3 {
+				t.Errorf("version %v should be greater than %v", v, v3)
+			}
+		})
+	}
+}
+
+func TestCompareVersionAndSwap(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).EvictType(tp).Build()
+
+			if ok, err := cache.CompareVersionAndSwap("a", 1, "stale"); ok || err != nil {
+				t.Errorf("unexpected result %v, %v", ok, err)
+			}
+			if ok, err := cache.CompareVersionAndSwap("a", 0, "first"); !ok || err != nil {
+				t.Errorf("unexpected result %v, %v", ok, err)
+			}
+
+			// Two writers read the same version; only the first one wins.
+			v, _ := cache.Version("a")
+			if ok, err := cache.CompareVersionAndSwap("a", v, "writer1"); !ok || err != nil {
+				t.Errorf("unexpected result %v, %v", ok, err)
+			}
+			if ok, err := cache.CompareVersionAndSwap("a", v, "writer2"); ok || err != nil {
+				t.Errorf("unexpected result %v, %v", ok, err)
+			}
+			if got, _ := cache.GetIFPresent("a"); got != "writer1" {
+				t.Errorf("%v != %v", got, "writer1")
+			}
+			if ok, _ := cache.CompareVersionAndSwap("a", 0, "again"); ok {
+				t.Error("a zero version should not match a present key")
+			}
+		})
+	}
+}
+
+func TestPauseEviction(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	size := 8
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(size).EvictType(tp).Build()
+			cache.PauseEviction()
+			cache.PauseEviction()
+			for i := 0; i < 2*size; i++ {
+				cache.Set(i, i)
+			}
+			if n := cache.Len(false); n != 2*size {
+				t.Errorf("%v != %v", n, 2*size)
+			}
+			cache.ResumeEviction()
+			if n := cache.Len(false); n != 2*size {
+				t.Errorf("%v != %v", n, 2*size)
+			}
+			cache.ResumeEviction()
+			if n := cache.Len(false); n != size {
+				t.Errorf("%v != %v", n, size)
+			}
+			// An unmatched resume does nothing.
+			cache.ResumeEviction()
+			cache.Set(2*size, 2*size)
+			if n := cache.Len(false); n != size {
+				t.Errorf("%v != %v", n, size)
+			}
+			if tp == TypeLru || tp == TypeArc {
+				// The least recently used keys were evicted.
+				for i := size + 1; i <= 2*size; i++ {
+					if !cache.Existed(i) {
+						t.Errorf("%v should not have been evicted", i)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestExpiredKeys(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(8).
+				EvictType(tp).
+				Clock(fc).
+				LazyExpireDisabled(true).
+				Build()
+			for i := 1; i <= 4; i++ {
+				cache.SetWithExpire(i, i*10, time.Duration(i)*time.Second)
+			}
+			cache.Set("forever", 0)
+			fc.Advance(2500 * time.Millisecond)
+
+			keys := cache.ExpiredKeys()
+			sort.Slice(keys, func(i, j int) bool { return keys[i].(int) < keys[j].(int) })
+			if !reflect.DeepEqual(keys, []interface{}{1, 2}) {
+				t.Errorf("%v != %v", keys, []interface{}{1, 2})
+			}
+
+			expired := make(map[interface{}]interface{})
+			cache.RangeExpired(func(key, value interface{}) bool {
+				expired[key] = value
+				return true
+			})
+			if !reflect.DeepEqual(expired, map[interface{}]interface{}{1: 10, 2: 20}) {
+				t.Errorf("%v != %v", expired, map[interface{}]interface{}{1: 10, 2: 20})
+			}
+			var visited int
+			cache.RangeExpired(func(key, value interface{}) bool {
+				visited++
+				return false
+			})
+			if visited != 1 {
+				t.Errorf("%v != %v", visited, 1)
+			}
+			if n := cache.Len(false); n != 5 {
+				t.Errorf("%v != %v", n, 5)
+			}
+		})
+	}
+}
+
+func TestRange(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			cache := New(4).
+				EvictType(tp).
+				Clock(fc).
+				DeserializeFunc(func(key, value interface{}) (interface{}, error) {
+					return value.(int) * 10, nil
+				}).
+				Build()
+			cache.Set(1, 1)
+			cache.Set(2, 2)
+			cache.SetWithExpire(3, 3, time.Second)
+			fc.Advance(2 * time.Second)
+
+			visited := map[interface{}]interface{}{}
+			cache.Range(true, func(key, value interface{}) bool {
+				visited[key] = value
+				// The lock is released while fn runs.
+				cache.Set("other", 0)
+				cache.Remove("other")
+				return true
+			})
+			if expected := map[interface{}]interface{}{1: 10, 2: 20}; !reflect.DeepEqual(visited, expected) {
+				t.Errorf("%v != %v", visited, expected)
+			}
+
+			var count int
+			cache.Range(false, func(key, value interface{}) bool {
+				count++
+				return true
+			})
+			if count != 3 {
+				t.Errorf("%v != %v", count, 3)
+			}
+
+			count = 0
+			cache.Range(false, func(key, value interface{}) bool {
+				count++
+				return false
+			})
+			if count != 1 {
+				t.Errorf("%v != %v", count, 1)
+			}
+		})
+	}
+}
+
+func TestKeysPage(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			const n = 100
+			cache := New(1000).EvictType(tp).Build()
+			for i := 0; i < n; i++ {
+				cache.Set(i, i)
+			}
+
+			done := make(chan struct{})
+			var wg sync.WaitGroup
+			for w := 0; w < 2; w++ {
+				wg.Add(1)
+				go func(w int) {
+					defer wg.Done()
+					for i := 0; ; i++ {
+						select {
+						case <-done:
+							return
+						default:
+						}
+						cache.GetIFPresent((i*7 + w) % n)
+						cache.Set(i%n, i)
+						cache.Set(fmt.Sprint("new", w, i%n), i)
+					}
+				}(w)
+			}
+
+			seen := map[interface{}]int{}
+			var cursor uint64
+			for {
+				page, next, total := cache.KeysPage(true, cursor, 7)
+				if total < n {
+					t.Fatalf("%v < %v", total, n)
+				}
+				if len(page) > 7 {
+					t.Fatalf("%v > %v", len(page), 7)
+				}
+				for _, key := range page {
+					seen[key]++
+				}
+				if next == 0 {
+					break
+				}
+				cursor = next
+			}
+			close(done)
+			wg.Wait()
+
+			for i := 0; i < n; i++ {
+				if seen[i] != 1 {
+					t.Errorf("%v seen %v times", i, seen[i])
+				}
+			}
+			for key, count := range seen {
+				if count != 1 {
+					t.Errorf("%v seen %v times", key, count)
+				}
+			}
+		})
+	}
+}
+
+func TestKeysPageOrder(t *testing.T) {
+	cache := New(8).LRU().Build()
+	for i := 0; i < 5; i++ {
+		cache.Set(i, i)
+	}
+	cache.GetIFPresent(1)
+	cache.Remove(2)
+	page, next, total := cache.KeysPage(false, 0, 3)
+	if expected := []interface{}{0, 1, 3}; !reflect.DeepEqual(page, expected) {
+		t.Errorf("%v != %v", page, expected)
+	}
+	if total != 4 {
+		t.Errorf("%v != %v", total, 4)
+	}
+	cache.Set(2, 2)
+	page, next, _ = cache.KeysPage(false, next, 3)
+	if expected := []interface{}{4, 2}; !reflect.DeepEqual(page, expected) {
+		t.Errorf("%v != %v", page, expected)
+	}
+	if next != 0 {
+		t.Errorf("%v != %v", next, 0)
+	}
+}
+
+func TestRangeKeepsLRUOrder(t *testing.T) {
+	cache := New(2).LRU().Build()
+	cache.Set("a", 1)
+	cache.Set("b", 2)
+	cache.Range(true, func(key, value interface{}) bool { return true })
+	cache.Set("c", 3)
+	if cache.Existed("a") {
+		t.Error("a should be evicted")
+	}
+}
+
+func TestGetMultiDetailed(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	errLoad := errors.New("load failed")
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var calls int32
+			release := make(chan struct{})
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					atomic.AddInt32(&calls, 1)
+					switch key {
+					case "fail":
+						return nil, errLoad
+					case "slow":
+						<-release
+					}
+					return fmt.Sprintf("loaded %v", key), nil
+				}).
+				Build()
+			cache.Set("warm", "cached")
+
+			values, loaded, err := cache.GetMultiDetailed(defaultCtx, []interface{}{"warm", "cold", "fail"})
+			var me *MultiError
+			if !errors.As(err, &me) || !errors.Is(me.Errors()["fail"], errLoad) {
+				t.Errorf("unexpected error %v", err)
+			}
+			expectedValues := map[interface{}]interface{}{"warm": "cached", "cold": "loaded cold"}
+			if !reflect.DeepEqual(values, expectedValues) {
+				t.Errorf("%v != %v", values, expectedValues)
+			}
+			expectedLoaded := map[interface{}]bool{"warm": false, "cold": true}
+			if !reflect.DeepEqual(loaded, expectedLoaded) {
+				t.Errorf("%v != %v", loaded, expectedLoaded)
+			}
+
+			// Callers sharing a single load all see the key as loaded.
+			atomic.StoreInt32(&calls, 0)
+			var wg sync.WaitGroup
+			results := make([]map[interface{}]bool, 4)
+			for i := range results {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					_, results[i], _ = cache.GetMultiDetailed(defaultCtx, []interface{}{"slow"})
+				}(i)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			for _, loaded := range results {
+				if !loaded["slow"] {
+					t.Errorf("%v should report slow as loaded", loaded)
+				}
+			}
+			if n := atomic.LoadInt32(&calls); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+		})
+	}
+}
+
+func TestGetMulti(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	errLoad := errors.New("load failed")
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					if key == "fail" {
+						return nil, errLoad
+					}
+					return fmt.Sprintf("loaded %v", key), nil
+				}).
+				Build()
+			cache.Set("warm", "cached")
+
+			values, err := cache.GetIFPresentMulti([]interface{}{"warm", "cold"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := map[interface{}]interface{}{"warm": "cached"}; !reflect.DeepEqual(values, expected) {
+				t.Errorf("%v != %v", values, expected)
+			}
+			if hits, misses := cache.HitCount(), cache.MissCount(); hits != 1 || misses != 1 {
+				t.Errorf("%v, %v != 1, 1", hits, misses)
+			}
+
+			values, err = cache.GetMulti(defaultCtx, []interface{}{"warm", "cold", "fail"})
+			if !errors.Is(err, errLoad) {
+				t.Errorf("%v should wrap %v", err, errLoad)
+			}
+			expected := map[interface{}]interface{}{"warm": "cached", "cold": "loaded cold"}
+			if !reflect.DeepEqual(values, expected) {
+				t.Errorf("%v != %v", values, expected)
+			}
+			if hits, misses := cache.HitCount(), cache.MissCount(); hits != 2 || misses != 3 {
+				t.Errorf("%v, %v != 2, 3", hits, misses)
+			}
+		})
+	}
+}
+
+func TestExpiredFunc(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			var calls []string
+			cache := New(2).
+				EvictType(tp).
+				Clock(fc).
+				EvictedFunc(func(key, value interface{}) {
+					calls = append(calls, fmt.Sprintf("evicted %v", key))
+				}).
+				ExpiredFunc(func(key, value interface{}) {
+					calls = append(calls, fmt.Sprintf("expired %v=%v", key, value))
+				}).
+				Build()
+
+			// An explicit removal is not an expiration.
+			cache.Set("removed", 0)
+			cache.Remove("removed")
+			// Neither is a capacity eviction.
+			for i := 0; i < 3; i++ {
+				cache.Set(i, i)
+			}
+			for _, call := range calls {
+				if strings.HasPrefix(call, "expired") {
+					t.Errorf("unexpected call %v", call)
+				}
+			}
+
+			// A lazily removed expired item.
+			cache.Purge()
+			calls = nil
+			cache.SetWithExpire("a", 1, time.Second)
+			fc.Advance(2 * time.Second)
+			cache.GetIFPresent("a")
+			expected := []string{"evicted a", "expired a=1"}
+			if !reflect.DeepEqual(calls, expected) {
+				t.Errorf("%v != %v", calls, expected)
+			}
+
+			// An expired item evicted first to make room.
+			calls = nil
+			cache.SetWithExpire("b", 2, time.Second)
+			cache.Set("c", 3)
+			fc.Advance(2 * time.Second)
+			cache.Set("d", 4)
+			expected = []string{"evicted b", "expired b=2"}
+			if !reflect.DeepEqual(calls, expected) {
+				t.Errorf("%v != %v", calls, expected)
+			}
+		})
+	}
+}
+
+func TestExpiredFuncOncePerKey(t *testing.T) {
+	var tps = []string{TypeSimple, TypeLru, TypeLfu, TypeArc}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			expired := make(map[interface{}]int)
+			evicted := 0
+			gc := New(10).
+				EvictType(tp).
+				Clock(fc).
+				Expiration(time.Second).
+				EvictedFunc(func(key, value interface{}) {
+					evicted++
+				}).
+				ExpiredFunc(func(key, value interface{}) {
+					expired[key]++
+				}).
+				Build()
+			for i := 0; i < 5; i++ {
+				gc.Set(i, i)
+			}
+			fc.Advance(2 * time.Second)
+			for n := 0; n < 3; n++ {
+				for i := 0; i < 5; i++ {
+					gc.GetIFPresent(i)
+					gc.Existed(i)
+				}
+			}
+			if len(expired) != 5 {
+				t.Errorf("%v != %v", len(expired), 5)
+			}
+			for key, n := range expired {
+				if n != 1 {
+					t.Errorf("key %v: %v != %v", key, n, 1)
+				}
+			}
+			if evicted != 5 {
+				t.Errorf("%v != %v", evicted, 5)
+			}
+		})
+	}
+}
+
+func TestSetCountsAsAccess(t *testing.T) {
+	var tps = []string{
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			for _, access := range []bool{true, false} {
+				cache := New(2).EvictType(tp).SetCountsAsAccess(access).Build()
+				cache.Set("a", 1)
+				cache.Set("b", 1)
+				cache.GetIFPresent("b")
+				cache.Set("a", 2)
+				cache.Set("a", 3)
+				cache.Set("c", 1)
+
+				// Writes to a promote it above b only if they count as accesses.
+				evicted, kept := "a", "b"
+				if access {
+					evicted, kept = "b", "a"
+				}
+				if cache.Existed(evicted) {
+					t.Errorf("access=%v: %v should have been evicted", access, evicted)
+				}
+				if !cache.Existed(kept) {
+					t.Errorf("access=%v: %v should not have been evicted", access, kept)
+				}
+			}
+		})
+	}
+}
+
+func TestSetCountsAsAccessDefault(t *testing.T) {
+	var tps = []string{
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(2).EvictType(tp).Build()
+			cache.Set("a", 1)
+			cache.Set("b", 1)
+			cache.GetIFPresent("b")
+			cache.Set("a", 2)
+			cache.Set("a", 3)
+			cache.Set("c", 1)
+
+			// Only LRU caches promote a key which is set by default.
+			kept := "b"
+			if tp == TypeLru {
+				kept = "a"
+			}
+			if !cache.Existed(kept) {
+				t.Errorf("%v should not have been evicted", kept)
+			}
+		})
+	}
+}
+
+func TestGetRaw(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var loads int
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					loads++
+					return key, nil
+				}).
+				SerializeFunc(func(k, v interface{}) (interface{}, error) {
+					return fmt.Sprintf("encoded:%v", v), nil
+				}).
+				DeserializeFunc(func(k, v interface{}) (interface{}, error) {
+					return strings.TrimPrefix(v.(string), "encoded:"), nil
+				}).
+				Build()
+			cache.Set("a", "value")
+
+			if v, ok := cache.GetRaw("a"); !ok || v != "encoded:value" {
+				t.Errorf("unexpected raw value %v, %v", v, ok)
+			}
+			if v, err := cache.Get(defaultCtx, "a"); err != nil || v != "value" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			if _, ok := cache.GetRaw("missing"); ok {
+				t.Error("a missing key should have no raw value")
+			}
+			if loads != 0 {
+				t.Errorf("GetRaw should not call the loader, called %v times", loads)
+			}
+			if n := cache.LookupCount(); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+		})
+	}
+}
+
+func TestBaseContext(t *testing.T) {
+	type ctxKey struct{}
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			base := context.WithValue(context.Background(), ctxKey{}, "tenant")
+			seen := make(chan interface{}, 1)
+			cache := New(8).
+				EvictType(tp).
+				BaseContext(base).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					seen <- ctx.Value(ctxKey{})
+					return key, ctx.Err()
+				}).
+				Build()
+
+			// The background refresh of GetIFPresent runs under the base context.
+			cache.GetIFPresent("a")
+			select {
+			case v := <-seen:
+				if v != "tenant" {
+					t.Errorf("%v != %v", v, "tenant")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the background refresh did not run")
+			}
+
+			// Get sees the values of the base context and its own cancellation.
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			if _, err := cache.Get(ctx, "b"); err != context.Canceled {
+				t.Errorf("%v != %v", err, context.Canceled)
+			}
+			if v := <-seen; v != "tenant" {
+				t.Errorf("%v != %v", v, "tenant")
+			}
+		})
+	}
+}
+
+func TestSetLoader(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			release := make(chan struct{})
+			started := make(chan struct{})
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					close(started)
+					<-release
+					return "old", nil
+				}).
+				Build()
+
+			done := make(chan interface{})
+			go func() {
+				v, _ := cache.Get(defaultCtx, "inflight")
+				done <- v
+			}()
+			<-started
+			cache.SetLoader(func(ctx context.Context, key interface{}) (interface{}, *time.Duration, error) {
+				return "new", nil, nil
+			})
+			if v, err := cache.Get(defaultCtx, "a"); err != nil || v != "new" {
+				t.Errorf("unexpected value %v, %v", v, err)
+			}
+			close(release)
+			if v := <-done; v != "old" {
+				t.Errorf("an in-flight load should keep the old loader: %v != %v", v, "old")
+			}
+
+			// Swap loaders while loads run.
+			var wg sync.WaitGroup
+			for i := 0; i < 4; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for j := 0; j < 100; j++ {
+						if i == 0 && j%10 == 0 {
+							cache.SetLoader(func(ctx context.Context, key interface{}) (interface{}, *time.Duration, error) {
+								return "new", nil, nil
+							})
+							continue
+						}
+						cache.Get(defaultCtx, fmt.Sprint(i, j))
+					}
+				}(i)
+			}
+			wg.Wait()
+
+			cache.SetLoader(nil)
+			if _, err := cache.Get(defaultCtx, "b"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+		})
+	}
+}
+
+func TestGetWithLoadGroup(t *testing.T) {
+	var tps = []string{
+		TypeSimple,
+		TypeLru,
+		TypeLfu,
+		TypeArc,
+	}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			cache := New(8).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					return "default", nil
+				}).
+				Build()
+			var calls int32
+			release := make(chan struct{})
+			loader := func(ctx context.Context) (interface{}, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return "batch", nil
+			}
+
+			var wg sync.WaitGroup
+			for _, key := range []string{"a", "b", "c"} {
+				wg.Add(1)
+				go func(key string) {
+					defer wg.Done()
+					if v, err := cache.GetWithLoadGroup(defaultCtx, key, "group", loader); err != nil || v != "batch" {
+						t.Errorf("unexpected value %v, %v", v, err)
+					}
+				}(key)
+			}
+			time.Sleep(20 * time.Millisecond)
+			close(release)
+			wg.Wait()
+			if n := atomic.LoadInt32(&calls); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+			for _, key := range []string{"a", "b", "c"} {
+				if v, err := cache.GetIFPresent(key); err != nil || v != "batch" {
+					t.Errorf("unexpected value %v, %v for key %v", v, err, key)
+				}
+			}
+
+			// A cached key does not call the loader.
+			if _, err := cache.GetWithLoadGroup(defaultCtx, "a", "group", loader); err != nil {
+				t.Error(err)
+			}
+			if n := atomic.LoadInt32(&calls); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+		})
+	}
+}
+
+func TestGetWithLoadGroupChangedDuringLoad(t *testing.T) {
+	cache := New(8).
+		LRU().
+		DropLoadIfRemoved(true).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			return "default", nil
+		}).
+		Build()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	loader := func(ctx context.Context) (interface{}, error) {
+		started <- struct{}{}
+		<-release
+		return "loaded", nil
+	}
+	for _, change := range []func(){
+		func() { cache.Set("key", "set") },
+		func() { cache.Remove("key") },
+	} {
+		cache.Purge()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			cache.GetWithLoadGroup(defaultCtx, "key", "group", loader)
+		}()
+		<-started
+		change()
+		release <- struct{}{}
+		<-done
+		if v, _ := cache.GetIFPresent("key"); v == "loaded" {
+			t.Error("a load should not overwrite a change made while it ran")
+		}
+	}
+}
+
+func TestCallbacksOutsideLock(t *testing.T) {
+	var tps = []string{TypeSimple, TypeLru, TypeLfu, TypeArc}
+	for _, tp := range tps {
+		t.Run(tp, func(t *testing.T) {
+			var gc Cache
+			purged := 0
+			gc = New(2).
+				EvictType(tp).
+				EvictedFunc(func(key, value interface{}) {
+					if key != "evicted" {
+						gc.Set("evicted", key)
+					}
+				}).
+				AddedFunc(func(key, value interface{}) {
+					gc.Len(false)
+				}).
+				PurgeVisitorFunc(func(key, value interface{}) {
+					purged++
+					gc.Len(false)
+				}).
+				Build()
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				gc.Set("a", 1)
+				gc.Remove("a")
+				gc.Set("b", 2)
+				gc.Purge()
+			}()
+			select {
+			case <-done:
+			case <-time.After(time.Second):
+				t.Fatal("a callback calling into the cache deadlocked")
+			}
+			if purged != 2 {
+				t.Errorf("%v != %v", purged, 2)
+			}
+
+			gc.Set("c", 3)
+			gc.Remove("c")
+			v, err := gc.GetIFPresent("evicted")
+			if err != nil || v != "c" {
+				t.Errorf("%v, %v != %v, nil", v, err, "c")
+			}
+		})
+	}
+}
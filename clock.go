@@ -2,16 +2,19 @@ package gcache
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-type clock interface {
+// Clock is the source of the current time of a cache, set with
+// CacheBuilder.Clock.
+type Clock interface {
 	Now() time.Time
 }
 
 type realClock struct{}
 
-func newRealClock() clock {
+func newRealClock() Clock {
 	return realClock{}
 }
 
@@ -20,13 +23,95 @@ func (rc realClock) Now() time.Time {
 	return t
 }
 
-type fakeClock interface {
-	clock
+// monotonicSource is implemented by clocks which also report a monotonic
+// time, i.e. one which is unaffected by steps of the wall clock.
+type monotonicSource interface {
+	Monotonic() time.Duration
+}
+
+// monotonicClock is a clock which only moves forward with the elapsed
+// monotonic time, starting from the time it was created at.
+type monotonicClock struct {
+	start     time.Time
+	startMono time.Duration
+	mono      func() time.Duration
+}
+
+// newMonotonicClock returns a clock deriving the time from the monotonic time
+// of c, or c itself if it has none.
+func newMonotonicClock(c Clock) Clock {
+	var mono func() time.Duration
+	switch src := c.(type) {
+	case monotonicSource:
+		mono = src.Monotonic
+	case realClock:
+		base := time.Now()
+		mono = func() time.Duration { return time.Since(base) }
+	default:
+		return c
+	}
+	return &monotonicClock{start: c.Now(), startMono: mono(), mono: mono}
+}
+
+func (mc *monotonicClock) Now() time.Time {
+	return mc.start.Add(mc.mono() - mc.startMono)
+}
+
+// CoarseClock is a clock which caches the current time and only refreshes it
+// every tick, saving a time.Now call on every cache operation. Its resolution
+// is tick, so it suits caches whose expirations are much longer than tick.
+type CoarseClock struct {
+	now     atomic.Value // time.Time
+	done    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+}
+
+// NewCoarseClock returns a CoarseClock refreshing the time every tick in a
+// background goroutine, which runs until Close is called.
+func NewCoarseClock(tick time.Duration) *CoarseClock {
+	cc := &CoarseClock{done: make(chan struct{}), stopped: make(chan struct{})}
+	cc.now.Store(time.Now())
+	go cc.run(tick)
+	return cc
+}
+
+func (cc *CoarseClock) run(tick time.Duration) {
+	ticker := time.NewTicker(tick)
+	defer close(cc.stopped)
+	defer ticker.Stop()
+	for {
+		select {
+		case t := <-ticker.C:
+			cc.now.Store(t)
+		case <-cc.done:
+			return
+		}
+	}
+}
+
+// Now returns the time of the last tick.
+func (cc *CoarseClock) Now() time.Time {
+	return cc.now.Load().(time.Time)
+}
+
+// Close stops refreshing the time and waits for the background goroutine to exit.
+func (cc *CoarseClock) Close() {
+	cc.once.Do(func() { close(cc.done) })
+	<-cc.stopped
+}
+
+// FakeClock is a Clock which only moves when it is advanced, for testing
+// expiration deterministically.
+type FakeClock interface {
+	Clock
 
+	// Advance moves the clock forward by d.
 	Advance(d time.Duration)
 }
 
-func newFakeClock() fakeClock {
+// NewFakeClock returns a FakeClock set to a fixed, non-zero time.
+func NewFakeClock() FakeClock {
 	return &fakeclock{
 		// Taken from github.com/jonboulle/clockwork: use a fixture that does not fulfill Time.IsZero()
 		now: time.Date(1984, time.April, 4, 0, 0, 0, 0, time.UTC),
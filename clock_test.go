@@ -0,0 +1,125 @@
+package gcache
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCoarseClock(t *testing.T) {
+	const tick = 5 * time.Millisecond
+	cc := NewCoarseClock(tick)
+	defer cc.Close()
+
+	start := cc.Now()
+	if d := time.Since(start); d < 0 || d > 10*tick {
+		t.Fatalf("coarse time is off by %v", d)
+	}
+
+	gc := New(8).Clock(cc).Expiration(50 * time.Millisecond).Build()
+	gc.Set("key", "value")
+	if _, err := gc.GetIFPresent("key"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50*time.Millisecond + 4*tick)
+	if _, err := gc.GetIFPresent("key"); err != ErrKeyNotFound {
+		t.Errorf("%v != %v", err, ErrKeyNotFound)
+	}
+
+	cc.Close()
+	cc.Close()
+	stopped := cc.Now()
+	time.Sleep(4 * tick)
+	if now := cc.Now(); !now.Equal(stopped) {
+		t.Errorf("%v != %v", now, stopped)
+	}
+}
+
+func BenchmarkClockNow(b *testing.B) {
+	cc := NewCoarseClock(time.Millisecond)
+	defer cc.Close()
+	for _, bc := range []struct {
+		name  string
+		clock Clock
+	}{
+		{"real", newRealClock()},
+		{"coarse", cc},
+	} {
+		b.Run(bc.name, func(b *testing.B) {
+			gc := New(1000).LRU().Clock(bc.clock).Expiration(time.Minute).Build()
+			for i := 0; i < b.N; i++ {
+				gc.Set(i%1000, i)
+				gc.GetIFPresent(i % 1000)
+			}
+		})
+	}
+}
+
+// steppingClock is a clock whose wall time can be stepped independently of
+// its monotonic time.
+type steppingClock struct {
+	mu   sync.Mutex
+	wall time.Time
+	mono time.Duration
+}
+
+func (sc *steppingClock) Now() time.Time {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.wall
+}
+
+func (sc *steppingClock) Monotonic() time.Duration {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	return sc.mono
+}
+
+// Advance lets d elapse.
+func (sc *steppingClock) Advance(d time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.wall = sc.wall.Add(d)
+	sc.mono += d
+}
+
+// Step moves the wall clock only, like an NTP adjustment.
+func (sc *steppingClock) Step(d time.Duration) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.wall = sc.wall.Add(d)
+}
+
+func TestMonotonicExpiry(t *testing.T) {
+	for _, monotonic := range []bool{true, false} {
+		sc := &steppingClock{wall: time.Date(1984, time.April, 4, 0, 0, 0, 0, time.UTC)}
+		gc := New(8).LRU().Clock(sc).MonotonicExpiry(monotonic).Build()
+		gc.SetWithExpire("backwards", 1, time.Second)
+
+		// A backwards step must not keep an item alive past its TTL.
+		sc.Step(-time.Hour)
+		sc.Advance(2 * time.Second)
+		if _, err := gc.GetIFPresent("backwards"); (err == ErrKeyNotFound) != monotonic {
+			t.Errorf("monotonic=%v: backwards: %v", monotonic, err)
+		}
+
+		// A forwards step must not expire an item early.
+		gc.SetWithExpire("forwards", 1, time.Minute)
+		sc.Step(2 * time.Hour)
+		if _, err := gc.GetIFPresent("forwards"); (err == nil) != monotonic {
+			t.Errorf("monotonic=%v: forwards: %v", monotonic, err)
+		}
+	}
+}
+
+func TestMonotonicExpiryRealClock(t *testing.T) {
+	gc := New(8).LRU().MonotonicExpiry(true).Build()
+	gc.SetWithExpire("key", 1, 20*time.Millisecond)
+	if _, err := gc.GetIFPresent("key"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if _, err := gc.GetIFPresent("key"); err != ErrKeyNotFound {
+		t.Errorf("%v != %v", err, ErrKeyNotFound)
+	}
+}
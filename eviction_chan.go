@@ -0,0 +1,89 @@
+package gcache
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// OverflowPolicy is what happens to an eviction event when the eviction
+// channel is full.
+type OverflowPolicy int
+
+const (
+	// DropNewest discards the new event. It is the default.
+	DropNewest OverflowPolicy = iota
+	// DropOldest discards the oldest unconsumed event to make room for the new one.
+	DropOldest
+	// Block waits until the consumer makes room. The event is sent after the
+	// cache lock is released, so the cache stays usable, but the call which
+	// evicted the item does not return until the event is sent, and other
+	// evictions wait behind it.
+	Block
+)
+
+// EvictionEvent is an item which left the cache, as sent on EvictionChan.
+type EvictionEvent struct {
+	Key   interface{}
+	Value interface{}
+}
+
+// evictionChan is the channel of eviction events of a cache.
+type evictionChan struct {
+	// mu serializes the senders, so that only the consumer competes with a
+	// sender for the channel.
+	mu      sync.Mutex
+	ch      chan EvictionEvent
+	policy  OverflowPolicy
+	dropped uint64
+}
+
+func newEvictionChan(buffer int, policy OverflowPolicy) *evictionChan {
+	return &evictionChan{ch: make(chan EvictionEvent, buffer), policy: policy}
+}
+
+// send sends ev according to the overflow policy. It is called after the
+// cache lock is released, so concurrent evictions may send their events in
+// a different order than the items left the cache.
+func (e *evictionChan) send(ev EvictionEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.policy == Block {
+		e.ch <- ev
+		return
+	}
+	for {
+		select {
+		case e.ch <- ev:
+			return
+		default:
+		}
+		if e.policy != DropOldest {
+			atomic.AddUint64(&e.dropped, 1)
+			return
+		}
+		select {
+		case <-e.ch:
+			atomic.AddUint64(&e.dropped, 1)
+		default:
+			// The consumer made room in the meantime.
+		}
+	}
+}
+
+// EvictionChan returns the channel receiving the items which left the cache,
+// or nil if the cache was not built with EvictionChanWithPolicy.
+func (c *baseCache) EvictionChan() <-chan EvictionEvent {
+	if c.evictionChan == nil {
+		return nil
+	}
+	return c.evictionChan.ch
+}
+
+// DroppedEvictions returns the number of eviction events discarded because
+// the eviction channel was full.
+func (c *baseCache) DroppedEvictions() uint64 {
+	if c.evictionChan == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&c.evictionChan.dropped)
+}
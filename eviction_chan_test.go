@@ -0,0 +1,111 @@
+package gcache
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func drainEvictions(c Cache) []interface{} {
+	var keys []interface{}
+	for {
+		select {
+		case ev := <-c.EvictionChan():
+			keys = append(keys, ev.Key)
+		default:
+			return keys
+		}
+	}
+}
+
+func TestEvictionChanOverflow(t *testing.T) {
+	for _, tc := range []struct {
+		policy   OverflowPolicy
+		expected []interface{}
+	}{
+		{DropNewest, []interface{}{0, 1}},
+		{DropOldest, []interface{}{2, 3}},
+	} {
+		gc := New(8).LRU().EvictionChanWithPolicy(2, tc.policy).Build()
+		for i := 0; i < 4; i++ {
+			gc.Set(i, i)
+		}
+		for i := 0; i < 4; i++ {
+			gc.Remove(i)
+		}
+		if keys := drainEvictions(gc); !reflect.DeepEqual(keys, tc.expected) {
+			t.Errorf("policy %v: %v != %v", tc.policy, keys, tc.expected)
+		}
+		if n := gc.DroppedEvictions(); n != 2 {
+			t.Errorf("policy %v: %v != %v", tc.policy, n, 2)
+		}
+	}
+}
+
+func TestEvictionChanBlock(t *testing.T) {
+	gc := New(8).LRU().EvictionChanWithPolicy(1, Block).Build()
+	gc.Set(0, 0)
+	gc.Set(1, 1)
+	gc.Remove(0)
+
+	done := make(chan struct{})
+	go func() {
+		gc.Remove(1)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("Remove should block while the eviction channel is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if ev := <-gc.EvictionChan(); ev.Key != 0 || ev.Value != 0 {
+		t.Errorf("unexpected event %v", ev)
+	}
+	<-done
+	if ev := <-gc.EvictionChan(); ev.Key != 1 {
+		t.Errorf("unexpected event %v", ev)
+	}
+	if n := gc.DroppedEvictions(); n != 0 {
+		t.Errorf("%v != %v", n, 0)
+	}
+}
+
+func TestEvictionChanDefault(t *testing.T) {
+	var policy OverflowPolicy
+	if policy != DropNewest {
+		t.Errorf("the default policy should be DropNewest, not %v", policy)
+	}
+	gc := New(8).LRU().Build()
+	gc.Set(0, 0)
+	gc.Remove(0)
+	if gc.EvictionChan() != nil {
+		t.Error("the eviction channel should be nil unless configured")
+	}
+}
+
+func TestEvictionChanConcurrentSenders(t *testing.T) {
+	for _, policy := range []OverflowPolicy{DropNewest, DropOldest} {
+		gc := New(1000).LRU().EvictionChanWithPolicy(4, policy).Build()
+		var wg sync.WaitGroup
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for i := 0; i < 100; i++ {
+					key := g*100 + i
+					gc.Set(key, key)
+					gc.Remove(key)
+				}
+			}(g)
+		}
+		wg.Wait()
+		keys := drainEvictions(gc)
+		if len(keys) != 4 {
+			t.Errorf("policy %v: %v != %v", policy, len(keys), 4)
+		}
+		if n := gc.DroppedEvictions(); n != 800-4 {
+			t.Errorf("policy %v: %v != %v", policy, n, 800-4)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package gcache
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// EvictionStatsSnapshot describes the items evicted to make room for new ones,
+// as tracked by TrackEvictionStats. Expired and removed items are not counted.
+type EvictionStatsSnapshot struct {
+	// Evictions is the number of items evicted.
+	Evictions uint64
+	// AverageAge is the average time the evicted items spent in the cache.
+	AverageAge time.Duration
+	// RecentlyReadFraction is the fraction of the evicted items which were
+	// read within the last recentReads reads of the cache. A high fraction
+	// means the cache evicts items still in use, i.e. it is too small.
+	RecentlyReadFraction float64
+}
+
+// evictionStats accumulates the ages and recency of evicted items.
+type evictionStats struct {
+	recentReads uint64
+	reads       uint64
+
+	evictions    uint64
+	totalAge     uint64
+	recentlyRead uint64
+}
+
+// markRead records a read of item.
+func (c *baseCache) markRead(item *cacheItem) {
+	if es := c.evictionStats; es != nil {
+		atomic.StoreUint64(&item.lastRead, atomic.AddUint64(&es.reads, 1))
+	}
+}
+
+// touch restarts the idle period of item and records a read of it.
+func (c *baseCache) touch(item *cacheItem) {
+	item.touch()
+	c.markRead(item)
+}
+
+// stampInserted records when item entered the cache: its seq and place in
+// the insertion order and, for TrackEvictionStats, the time.
+func (c *baseCache) stampInserted(item *cacheItem) {
+	c.lastSeq++
+	item.seq = c.lastSeq
+	c.appendOrder(item)
+	if c.evictionStats != nil {
+		item.insertedAt = c.clock.Now()
+	}
+}
+
+// recordVictim records the eviction of item to make room for another one.
+func (c *baseCache) recordVictim(item *cacheItem) {
+	es := c.evictionStats
+	if es == nil {
+		return
+	}
+	atomic.AddUint64(&es.evictions, 1)
+	if age := c.clock.Now().Sub(item.insertedAt); age > 0 {
+		atomic.AddUint64(&es.totalAge, uint64(age))
+	}
+	lastRead := atomic.LoadUint64(&item.lastRead)
+	if lastRead > 0 && atomic.LoadUint64(&es.reads)-lastRead < es.recentReads {
+		atomic.AddUint64(&es.recentlyRead, 1)
+	}
+}
+
+// EvictionStats returns the stats of the evicted items. It is zero unless the
+// cache was built with TrackEvictionStats.
+func (c *baseCache) EvictionStats() EvictionStatsSnapshot {
+	es := c.evictionStats
+	if es == nil {
+		return EvictionStatsSnapshot{}
+	}
+	s := EvictionStatsSnapshot{Evictions: atomic.LoadUint64(&es.evictions)}
+	if s.Evictions > 0 {
+		s.AverageAge = time.Duration(atomic.LoadUint64(&es.totalAge) / s.Evictions)
+		s.RecentlyReadFraction = float64(atomic.LoadUint64(&es.recentlyRead)) / float64(s.Evictions)
+	}
+	return s
+}
@@ -2,20 +2,58 @@ package gcache
 
 import (
 	"container/list"
-	"context"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
+const (
+	// lfuIncrementShards is the number of buffers batched increments are spread over.
+	lfuIncrementShards = 16
+	// lfuIncrementBatch is the number of increments a buffer holds before it
+	// forces the buffered increments to be applied.
+	lfuIncrementBatch = 64
+)
+
+// LFUInspector exposes the access frequencies of a cache built with LFU().
+// Reach it with a type assertion:
+//
+//	if lfu, ok := gc.(gcache.LFUInspector); ok {
+//		fmt.Println(lfu.FreqDistribution())
+//	}
+type LFUInspector interface {
+	// FreqDistribution returns the number of items for each access frequency
+	// that at least one item currently has.
+	FreqDistribution() map[uint]int
+	// LFUMinFreqKeys returns the keys with the lowest access frequency, which
+	// are the candidates for the next eviction, in no particular order.
+	LFUMinFreqKeys() []interface{}
+}
+
 // Discards the least frequently used items first.
 type lfuCache struct {
 	baseCache
 	items    map[interface{}]*lfuItem
 	freqList *list.List // list for freqEntry
+
+	// increments buffers the frequency increments of read hits if the cache
+	// was built with LFUBatchedIncrement. It is nil otherwise.
+	increments    []lfuIncrements
+	nextIncrement uint32
+}
+
+// lfuIncrements is a buffer of items whose frequency is yet to be incremented.
+type lfuIncrements struct {
+	mu    sync.Mutex
+	items []*lfuItem
 }
 
 func newLFUCache(cb *CacheBuilder) *lfuCache {
 	c := &lfuCache{}
 	buildCache(&c.baseCache, c, cb)
+	if cb.lfuBatchedIncrement {
+		c.increments = make([]lfuIncrements, lfuIncrementShards)
+	}
 
 	c.init()
 	c.loadGroup.cache = c
@@ -25,48 +63,39 @@ func newLFUCache(cb *CacheBuilder) *lfuCache {
 func (c *lfuCache) init() {
 	c.freqList = list.New()
 	c.items = make(map[interface{}]*lfuItem, c.size+1)
+	c.peakItems = c.size + 1
+	// Every new item enters the freq-0 bucket, so size it for a full cache
+	// up front instead of rehashing it while the cache warms up.
 	c.freqList.PushFront(&freqEntry{
 		freq:  0,
-		items: make(map[*lfuItem]struct{}),
+		items: make(map[*lfuItem]struct{}, c.size),
 	})
 }
 
-func (c *lfuCache) SetWithExpire(key, value interface{}, expiration time.Duration) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	item, err := c.set(key, value)
-	if err != nil {
-		return err
-	}
-
-	t := c.clock.Now().Add(expiration)
-	item.(*lfuItem).expiration = &t
-	return nil
-}
-
 func (c *lfuCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, err := c.serialize(key, value)
+	if err != nil {
+		return nil, err
 	}
+	c.applyIncrements()
 
 	// Check for existing item
 	item, ok := c.items[key]
 	if ok {
-		item.value = value
+		item.value = c.soft(value)
+		if c.setCountsAsAccess {
+			c.increment(item)
+		}
 	} else {
 		// Verify size not exceeded
-		if len(c.items) >= c.size {
+		if len(c.items) >= c.size && c.evictionPauses == 0 {
 			c.evict(1)
 		}
 		item = &lfuItem{
 			cacheItem: cacheItem{
 				clock: c.clock,
 				key:   key,
-				value: value,
+				value: c.soft(value),
 			},
 			freqElement: nil,
 		}
@@ -75,39 +104,23 @@ func (c *lfuCache) set(key, value interface{}) (interface{}, error) {
 		fe.items[item] = struct{}{}
 
 		item.freqElement = el
+		c.stampInserted(&item.cacheItem)
 		c.items[key] = item
+		c.trackPeak(len(c.items))
+		c.stats.observeLen(len(c.items))
 	}
 
 	if c.expiration != nil {
 		t := c.clock.Now().Add(*c.expiration)
 		item.expiration = &t
 	}
+	item.setMaxIdle(c.maxIdle)
+	item.version = c.nextVersion()
 
-	if c.addedFunc != nil {
-		c.addedFunc(key, value)
-	}
-
-	return item, nil
-}
-
-func (c *lfuCache) Get(ctx context.Context, key interface{}) (interface{}, error) {
-	v, err := c.cache.get(key, false)
-	if err == ErrKeyNotFound {
-		return c.getWithLoader(ctx, key, true)
-	}
-	return v, err
-}
-
-func (c *lfuCache) Refresh(ctx context.Context, key interface{}) (interface{}, error) {
-	return c.getWithLoader(ctx, key, true)
-}
+	c.notifyAdded(key, value)
 
-func (c *lfuCache) GetIFPresent(key interface{}) (interface{}, error) {
-	v, err := c.cache.get(key, false)
-	if err == ErrKeyNotFound {
-		return c.getWithLoader(context.Background(), key, false)
-	}
-	return v, nil
+	c.recordSet(key)
+	return &item.cacheItem, nil
 }
 
 func (c *lfuCache) get(key interface{}, onLoad bool) (interface{}, error) {
@@ -115,60 +128,40 @@ func (c *lfuCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	if c.deserializeFunc != nil {
-		return c.deserializeFunc(key, v)
+	v, err = c.deserialize(key, v)
+	if !onLoad {
+		c.recordGet(key, err == nil)
 	}
-	return v, nil
+	return v, err
 }
 
 func (c *lfuCache) getValue(key interface{}, onLoad bool) (interface{}, error) {
+	if c.increments != nil {
+		if v, ok := c.getValueBatched(key); ok {
+			return v, nil
+		}
+	}
 	c.mu.Lock()
 	item, ok := c.items[key]
 	if ok {
-		if !item.IsExpired(nil) {
+		if v, ok := item.liveValue(nil); ok {
+			c.touch(&item.cacheItem)
 			c.increment(item)
-			v := item.value
-			c.mu.Unlock()
-			if !onLoad {
-				c.stats.IncrHitCount()
-			}
+			c.unlock()
 			return v, nil
 		}
-		c.removeItem(item)
+		if !c.lazyExpireDisabled {
+			c.removeItem(item)
+			c.notifyExpired(item.key, item.value)
+		}
 	}
-	c.mu.Unlock()
+	c.unlock()
 	if !onLoad {
-		c.stats.IncrMissCount()
+		c.recordGet(key, false)
 	}
 	return nil, ErrKeyNotFound
 }
 
-func (c *lfuCache) getWithLoader(ctx context.Context, key interface{}, isWait bool) (interface{}, error) {
-	if c.loaderExpireFunc == nil {
-		return nil, ErrKeyNotFound
-	}
-	value, _, err := c.load(ctx, key, func(v interface{}, expiration *time.Duration, e error) (interface{}, error) {
-		if e != nil {
-			return nil, e
-		}
-		c.mu.Lock()
-		defer c.mu.Unlock()
-		item, err := c.set(key, v)
-		if err != nil {
-			return nil, err
-		}
-		if expiration != nil {
-			t := c.clock.Now().Add(*expiration)
-			item.(*lfuItem).expiration = &t
-		}
-		return v, nil
-	}, isWait)
-	if err != nil {
-		return nil, err
-	}
-	return value, nil
-}
-
 func (c *lfuCache) increment(item *lfuItem) {
 	currentFreqElement := item.freqElement
 	currentFreqEntry := currentFreqElement.Value.(*freqEntry)
@@ -176,7 +169,7 @@ func (c *lfuCache) increment(item *lfuItem) {
 	delete(currentFreqEntry.items, item)
 
 	nextFreqElement := currentFreqElement.Next()
-	if nextFreqElement == nil {
+	if nextFreqElement == nil || nextFreqElement.Value.(*freqEntry).freq != nextFreq {
 		nextFreqElement = c.freqList.InsertAfter(&freqEntry{
 			freq:  nextFreq,
 			items: make(map[*lfuItem]struct{}),
@@ -184,31 +177,187 @@ func (c *lfuCache) increment(item *lfuItem) {
 	}
 	nextFreqElement.Value.(*freqEntry).items[item] = struct{}{}
 	item.freqElement = nextFreqElement
+	c.removeIfEmpty(currentFreqElement)
+}
+
+// removeIfEmpty removes the frequency entry e from freqList if it has no items
+// left, unless it is the entry of the new items at the front.
+func (c *lfuCache) removeIfEmpty(e *list.Element) {
+	if fe := e.Value.(*freqEntry); len(fe.items) == 0 && fe.freq != 0 {
+		c.freqList.Remove(e)
+	}
+}
+
+// getValueBatched looks up a live item under the read lock and buffers the
+// increment of its frequency. It reports false if the item has to be handled
+// under the write lock instead, because it is missing, expired or idle-tracked.
+func (c *lfuCache) getValueBatched(key interface{}) (interface{}, bool) {
+	c.mu.RLock()
+	item, ok := c.items[key]
+	if !ok || item.maxIdle > 0 {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	v, ok := item.liveValue(nil)
+	if !ok {
+		c.mu.RUnlock()
+		return nil, false
+	}
+	c.markRead(&item.cacheItem)
+	full := c.bufferIncrement(item)
+	c.mu.RUnlock()
+
+	if full {
+		c.mu.Lock()
+		c.applyIncrements()
+		c.unlock()
+	}
+	return v, true
+}
+
+// bufferIncrement buffers an increment of the frequency of item and reports
+// whether its buffer is full.
+func (c *lfuCache) bufferIncrement(item *lfuItem) bool {
+	buf := &c.increments[atomic.AddUint32(&c.nextIncrement, 1)%lfuIncrementShards]
+	buf.mu.Lock()
+	defer buf.mu.Unlock()
+	buf.items = append(buf.items, item)
+	return len(buf.items) >= lfuIncrementBatch
+}
+
+// applyIncrements applies the buffered frequency increments of items which
+// are still in the cache. It must be called with the write lock held.
+func (c *lfuCache) applyIncrements() {
+	for i := range c.increments {
+		buf := &c.increments[i]
+		buf.mu.Lock()
+		items := buf.items
+		buf.items = nil
+		buf.mu.Unlock()
+		for _, item := range items {
+			if c.items[item.key] == item {
+				c.increment(item)
+			}
+		}
+	}
+}
+
+// flushIncrements applies the buffered frequency increments, taking the
+// write lock only if there are any.
+func (c *lfuCache) flushIncrements() {
+	pending := false
+	for i := range c.increments {
+		buf := &c.increments[i]
+		buf.mu.Lock()
+		pending = pending || len(buf.items) > 0
+		buf.mu.Unlock()
+	}
+	if !pending {
+		return
+	}
+	c.mu.Lock()
+	c.applyIncrements()
+	c.unlock()
+}
+
+// FreqDistribution returns the number of items for each access frequency
+// that at least one item currently has. It reads them under the read lock,
+// once the buffered increments, if any, are applied.
+func (c *lfuCache) FreqDistribution() map[uint]int {
+	c.flushIncrements()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	dist := make(map[uint]int)
+	for e := c.freqList.Front(); e != nil; e = e.Next() {
+		fe := e.Value.(*freqEntry)
+		if len(fe.items) > 0 {
+			dist[fe.freq] = len(fe.items)
+		}
+	}
+	return dist
+}
+
+// LFUMinFreqKeys returns the keys with the lowest access frequency,
+// which are the candidates for the next eviction, in no particular order.
+func (c *lfuCache) LFUMinFreqKeys() []interface{} {
+	c.flushIncrements()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for e := c.freqList.Front(); e != nil; e = e.Next() {
+		fe := e.Value.(*freqEntry)
+		if len(fe.items) == 0 {
+			continue
+		}
+		keys := make([]interface{}, 0, len(fe.items))
+		for item := range fe.items {
+			keys = append(keys, c.decodeKey(item.key))
+		}
+		return keys
+	}
+	return nil
 }
 
 // evict removes the least frequencies item from the cache.
 func (c *lfuCache) evict(count int) {
+	if c.evictExpiredFirst {
+		count -= c.evictExpired(count)
+	}
 	entry := c.freqList.Front()
 	for i := 0; i < count; {
 		if entry == nil {
 			return
 		}
+		// Removing the last item of entry removes entry from the list.
+		next := entry.Next()
 		for item := range entry.Value.(*freqEntry).items {
 			if i >= count {
 				return
 			}
+			c.recordVictim(&item.cacheItem)
 			c.removeItem(item)
 			i++
 		}
-		entry = entry.Next()
+		entry = next
+	}
+}
+
+// evictOverflow evicts the items exceeding the size of the cache.
+func (c *lfuCache) evictOverflow() {
+	c.applyIncrements()
+	if n := len(c.items) - c.size; n > 0 {
+		c.evict(n)
+	}
+}
+
+// evictExpired removes up to count expired items among the least frequently
+// used ones and returns the number of items removed.
+func (c *lfuCache) evictExpired(count int) int {
+	now := c.clock.Now()
+	removed, probed := 0, 0
+	for e := c.freqList.Front(); e != nil && probed < expiredProbeLimit && removed < count; {
+		next := e.Next()
+		for item := range e.Value.(*freqEntry).items {
+			if probed >= expiredProbeLimit || removed >= count {
+				break
+			}
+			probed++
+			if item.IsExpired(&now) {
+				c.removeItem(item)
+				c.notifyExpired(item.key, item.value)
+				removed++
+			}
+		}
+		e = next
 	}
+	return removed
 }
 
 func (c *lfuCache) Existed(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	now := time.Now()
-	return c.has(key, &now)
+	now := c.clock.Now()
+	return c.existed(c.has(key, &now))
 }
 
 func (c *lfuCache) has(key interface{}, now *time.Time) bool {
@@ -219,11 +368,37 @@ func (c *lfuCache) has(key interface{}, now *time.Time) bool {
 	return !item.IsExpired(now)
 }
 
+// peek returns the item for key without updating its frequency, or nil.
+func (c *lfuCache) peek(key interface{}) *cacheItem {
+	if item, ok := c.items[key]; ok {
+		return &item.cacheItem
+	}
+	return nil
+}
+
+// access records a read of the item for key, incrementing its frequency.
+func (c *lfuCache) access(key interface{}) {
+	if item, ok := c.items[key]; ok {
+		c.touch(&item.cacheItem)
+		c.increment(item)
+	}
+}
+
+// forEach calls fn for every item until fn returns false.
+func (c *lfuCache) forEach(fn func(item *cacheItem) bool) {
+	for _, item := range c.items {
+		if !fn(&item.cacheItem) {
+			return
+		}
+	}
+}
+
 func (c *lfuCache) Remove(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	return c.remove(key)
+	return c.recordRemove(key, c.remove(key))
 }
 
 func (c *lfuCache) remove(key interface{}) bool {
@@ -238,9 +413,8 @@ func (c *lfuCache) remove(key interface{}) bool {
 func (c *lfuCache) removeItem(item *lfuItem) {
 	delete(c.items, item.key)
 	delete(item.freqElement.Value.(*freqEntry).items, item)
-	if c.evictedFunc != nil {
-		c.evictedFunc(item.key, item.value)
-	}
+	c.removeIfEmpty(item.freqElement)
+	c.notifyEvicted(item.key, item.value)
 }
 
 func (c *lfuCache) keys() []interface{} {
@@ -255,27 +429,14 @@ func (c *lfuCache) keys() []interface{} {
 	return keys
 }
 
-func (c *lfuCache) GetALL(checkExpired bool) map[interface{}]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	items := make(map[interface{}]interface{}, len(c.items))
-	now := time.Now()
-	for k, item := range c.items {
-		if !checkExpired || c.has(k, &now) {
-			items[k] = item.value
-		}
-	}
-	return items
-}
-
 func (c *lfuCache) Keys(checkExpired bool) []interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	keys := make([]interface{}, 0, len(c.items))
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if !checkExpired || c.has(k, &now) {
-			keys = append(keys, k)
+			keys = append(keys, c.decodeKey(k))
 		}
 	}
 	return keys
@@ -288,7 +449,7 @@ func (c *lfuCache) Len(checkExpired bool) int {
 		return len(c.items)
 	}
 	var length int
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if c.has(k, &now) {
 			length++
@@ -297,16 +458,45 @@ func (c *lfuCache) Len(checkExpired bool) int {
 	return length
 }
 
+// Compact rebuilds the items map and the per-frequency item sets if the cache
+// has shrunk far below its peak size. Item frequencies are preserved.
+func (c *lfuCache) Compact() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.needCompact(len(c.items)) {
+		return
+	}
+	items := make(map[interface{}]*lfuItem, len(c.items))
+	for k, item := range c.items {
+		items[k] = item
+	}
+	c.items = items
+	c.peakItems = len(items)
+
+	for e := c.freqList.Front(); e != nil; e = e.Next() {
+		fe := e.Value.(*freqEntry)
+		feItems := make(map[*lfuItem]struct{}, len(fe.items))
+		for item := range fe.items {
+			feItems[item] = struct{}{}
+		}
+		fe.items = feItems
+	}
+}
+
 func (c *lfuCache) Purge() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	if c.purgeVisitorFunc != nil {
 		for key, item := range c.items {
-			c.purgeVisitorFunc(key, item.value)
+			c.notifyPurged(key, item.value)
 		}
 	}
 
+	c.tags = nil
+	c.order = insertionOrder{}
+	c.clearLoaderErrors()
 	c.init()
 }
 
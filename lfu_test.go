@@ -2,6 +2,10 @@ package gcache
 
 import (
 	"fmt"
+	"math/rand"
+	"reflect"
+	"sort"
+	"sync"
 	"testing"
 	"time"
 )
@@ -83,3 +87,245 @@ func TestLFUHas(t *testing.T) {
 		})
 	}
 }
+
+func TestLFUFreqDistribution(t *testing.T) {
+	gc := New(8).LFU().Build()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		gc.Set(key, key)
+	}
+	for i := 0; i < 3; i++ {
+		gc.GetIFPresent("a")
+	}
+	gc.GetIFPresent("b")
+	gc.GetIFPresent("c")
+
+	dist := gc.(*lfuCache).FreqDistribution()
+	expected := map[uint]int{0: 1, 1: 2, 3: 1}
+	if len(dist) != len(expected) {
+		t.Fatalf("%v != %v", dist, expected)
+	}
+	for freq, n := range expected {
+		if dist[freq] != n {
+			t.Errorf("freq %v: %v != %v", freq, dist[freq], n)
+		}
+	}
+}
+
+func TestLFUFreqListBounded(t *testing.T) {
+	gc := New(16).LFU().Build()
+	lfu := gc.(*lfuCache)
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		key := r.Intn(64)
+		switch r.Intn(4) {
+		case 0:
+			gc.Set(key, i)
+		case 1:
+			gc.Remove(key)
+		default:
+			gc.GetIFPresent(key)
+		}
+	}
+
+	lfu.mu.Lock()
+	defer lfu.mu.Unlock()
+	freqs := map[uint]bool{0: true}
+	for _, item := range lfu.items {
+		freqs[item.freqElement.Value.(*freqEntry).freq] = true
+	}
+	if n := lfu.freqList.Len(); n > len(freqs) {
+		t.Errorf("%v entries > %v distinct frequencies", n, len(freqs))
+	}
+	var last uint
+	for e := lfu.freqList.Front(); e != nil; e = e.Next() {
+		fe := e.Value.(*freqEntry)
+		if e != lfu.freqList.Front() && fe.freq <= last {
+			t.Errorf("freq %v after %v", fe.freq, last)
+		}
+		last = fe.freq
+		for item := range fe.items {
+			if item.freqElement != e {
+				t.Errorf("%v is in the wrong entry", item.key)
+			}
+		}
+	}
+}
+
+func TestLFUMinFreqKeys(t *testing.T) {
+	gc := New(3).LFU().Build()
+	lfu := gc.(*lfuCache)
+	if keys := lfu.LFUMinFreqKeys(); len(keys) != 0 {
+		t.Errorf("%v != []", keys)
+	}
+
+	for _, key := range []string{"a", "b", "c"} {
+		gc.Set(key, key)
+	}
+	gc.GetIFPresent("a")
+	gc.GetIFPresent("b")
+	if keys := lfu.LFUMinFreqKeys(); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("%v != [c]", keys)
+	}
+
+	gc.GetIFPresent("c")
+	gc.GetIFPresent("a")
+	keys := lfu.LFUMinFreqKeys()
+	sort.Slice(keys, func(i, j int) bool { return keys[i].(string) < keys[j].(string) })
+	if len(keys) != 2 || keys[0] != "b" || keys[1] != "c" {
+		t.Fatalf("%v != [b c]", keys)
+	}
+
+	// The next eviction takes one of the reported keys.
+	gc.Set("d", "d")
+	if gc.Existed("b") && gc.Existed("c") {
+		t.Error("b or c should have been evicted")
+	}
+	if !gc.Existed("a") {
+		t.Error("a should not have been evicted")
+	}
+}
+
+func BenchmarkLFUFill(b *testing.B) {
+	const size = 10000
+	keys := make([]interface{}, size)
+	for i := range keys {
+		keys[i] = i
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		gc := New(size).LFU().Build()
+		for _, key := range keys {
+			gc.Set(key, key)
+		}
+	}
+}
+
+func TestLFUBatchedIncrement(t *testing.T) {
+	gc := New(3).LFU().LFUBatchedIncrement(true).Build()
+	for _, key := range []string{"a", "b", "c"} {
+		gc.Set(key, key)
+	}
+	for i := 0; i < 5; i++ {
+		gc.GetIFPresent("a")
+	}
+	for i := 0; i < 2; i++ {
+		gc.GetIFPresent("b")
+	}
+
+	dist := gc.(*lfuCache).FreqDistribution()
+	expected := map[uint]int{0: 1, 2: 1, 5: 1}
+	if len(dist) != len(expected) {
+		t.Fatalf("%v != %v", dist, expected)
+	}
+	for freq, n := range expected {
+		if dist[freq] != n {
+			t.Errorf("freq %v: %v != %v", freq, dist[freq], n)
+		}
+	}
+
+	// Buffered increments are applied before an eviction.
+	gc.GetIFPresent("c")
+	gc.GetIFPresent("c")
+	gc.GetIFPresent("c")
+	gc.Set("d", "d")
+	if gc.Existed("b") {
+		t.Error("b should have been evicted")
+	}
+	if !gc.Existed("a") || !gc.Existed("c") {
+		t.Error("a and c should not have been evicted")
+	}
+}
+
+func TestLFUInspectorReadLock(t *testing.T) {
+	gc := New(3).LFU().LFUBatchedIncrement(true).Build()
+	gc.Set("a", 1)
+	gc.GetIFPresent("a")
+	c := gc.(*lfuCache)
+	// The buffered increment is applied under the write lock first.
+	if dist := c.FreqDistribution(); dist[1] != 1 {
+		t.Errorf("%v != %v", dist, map[uint]int{1: 1})
+	}
+
+	// With nothing buffered, a reader holding the lock does not block them.
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		c.FreqDistribution()
+		c.LFUMinFreqKeys()
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("FreqDistribution and LFUMinFreqKeys should only take the read lock")
+	}
+}
+
+func TestLFUBatchedIncrementConcurrent(t *testing.T) {
+	gc := New(8).LFU().LFUBatchedIncrement(true).Build()
+	gc.Set("hot", 1)
+	gc.Set("cold", 1)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				gc.GetIFPresent("hot")
+				if j%100 == 0 {
+					gc.GetIFPresent("cold")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	keys := gc.(*lfuCache).LFUMinFreqKeys()
+	if len(keys) != 1 || keys[0] != "cold" {
+		t.Errorf("%v != [cold]", keys)
+	}
+	if hits := gc.HitCount(); hits != 8*1010 {
+		t.Errorf("%v != %v", hits, 8*1010)
+	}
+}
+
+func BenchmarkLFUGetParallel(b *testing.B) {
+	for _, batched := range []bool{false, true} {
+		b.Run(fmt.Sprintf("batched=%v", batched), func(b *testing.B) {
+			const size = 1000
+			gc := New(size).LFU().LFUBatchedIncrement(batched).Build()
+			for i := 0; i < size; i++ {
+				gc.Set(i, i)
+			}
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					// Read a few hot keys only.
+					gc.GetIFPresent(i % 16)
+					i++
+				}
+			})
+		})
+	}
+}
+
+func TestLFUInspector(t *testing.T) {
+	gc := New(8).LFU().Build()
+	lfu, ok := gc.(LFUInspector)
+	if !ok {
+		t.Fatal("an LFU cache should implement LFUInspector")
+	}
+	gc.Set("a", 1)
+	gc.Set("b", 2)
+	gc.GetIFPresent("a")
+	if d := lfu.FreqDistribution(); !reflect.DeepEqual(d, map[uint]int{0: 1, 1: 1}) {
+		t.Errorf("%v != %v", d, map[uint]int{0: 1, 1: 1})
+	}
+	if keys := lfu.LFUMinFreqKeys(); !reflect.DeepEqual(keys, []interface{}{"b"}) {
+		t.Errorf("%v != %v", keys, []interface{}{"b"})
+	}
+	if _, ok := New(8).LRU().Build().(LFUInspector); ok {
+		t.Error("an LRU cache should not implement LFUInspector")
+	}
+}
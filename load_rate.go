@@ -0,0 +1,61 @@
+package gcache
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// loadRateBuckets is the number of buckets the load rate window is split into.
+	loadRateBuckets = 10
+	// loadRateBucket is the duration covered by each bucket, making a window of one second.
+	loadRateBucket = time.Second / loadRateBuckets
+)
+
+// loadRate counts loader calls over a sliding window of one second.
+type loadRate struct {
+	mu       sync.Mutex
+	counts   [loadRateBuckets]uint64
+	slots    [loadRateBuckets]int64
+	exceeded bool
+}
+
+// record counts a loader call at now and returns the rate including it.
+func (lr *loadRate) record(now time.Time) float64 {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	slot := now.UnixNano() / int64(loadRateBucket)
+	i := slot % loadRateBuckets
+	if lr.slots[i] != slot {
+		lr.slots[i] = slot
+		lr.counts[i] = 0
+	}
+	lr.counts[i]++
+	return lr.sum(slot)
+}
+
+// rate returns the number of loader calls in the second up to now.
+func (lr *loadRate) rate(now time.Time) float64 {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	return lr.sum(now.UnixNano() / int64(loadRateBucket))
+}
+
+func (lr *loadRate) sum(slot int64) float64 {
+	var n uint64
+	for i, s := range lr.slots {
+		if slot-s < loadRateBuckets {
+			n += lr.counts[i]
+		}
+	}
+	return float64(n)
+}
+
+// checkExceeded reports whether rate has just risen above max.
+func (lr *loadRate) checkExceeded(rate, max float64) bool {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	was := lr.exceeded
+	lr.exceeded = rate > max
+	return lr.exceeded && !was
+}
@@ -0,0 +1,61 @@
+package gcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestLoadRate(t *testing.T) {
+	fc := NewFakeClock()
+	var exceeded int
+	gc := New(100).
+		LRU().
+		Clock(fc).
+		MaxLoadRate(5, func() {
+			exceeded++
+		}).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			return key, nil
+		}).
+		Build()
+
+	key := 0
+	load := func(n int) {
+		for i := 0; i < n; i++ {
+			gc.Get(defaultCtx, key)
+			key++
+		}
+	}
+
+	load(4)
+	// Hits do not count as loads.
+	gc.Get(defaultCtx, 0)
+	if r := gc.LoadRatePerSecond(); r != 4 {
+		t.Errorf("%v != %v", r, 4)
+	}
+	fc.Advance(500 * time.Millisecond)
+	load(4)
+	if r := gc.LoadRatePerSecond(); r != 8 {
+		t.Errorf("%v != %v", r, 8)
+	}
+	if exceeded != 1 {
+		t.Errorf("%v != %v", exceeded, 1)
+	}
+
+	// The first loads slide out of the window.
+	fc.Advance(600 * time.Millisecond)
+	if r := gc.LoadRatePerSecond(); r != 4 {
+		t.Errorf("%v != %v", r, 4)
+	}
+	fc.Advance(time.Second)
+	if r := gc.LoadRatePerSecond(); r != 0 {
+		t.Errorf("%v != %v", r, 0)
+	}
+
+	// The callback fires again once the rate has dropped and risen again.
+	load(6)
+	if exceeded != 2 {
+		t.Errorf("%v != %v", exceeded, 2)
+	}
+}
@@ -10,10 +10,14 @@ type lruCache struct {
 	baseCache
 	items     map[interface{}]*list.Element
 	evictList *list.List
+
+	// recycleNodes reuses the node of an evicted or expired item for the new
+	// one. See CacheBuilder.RecycleNodes.
+	recycleNodes bool
 }
 
 func newLRUCache(cb *CacheBuilder) *lruCache {
-	c := &lruCache{}
+	c := &lruCache{recycleNodes: cb.recycleNodes}
 	buildCache(&c.baseCache, c, cb)
 
 	c.init()
@@ -24,65 +28,78 @@ func newLRUCache(cb *CacheBuilder) *lruCache {
 func (c *lruCache) init() {
 	c.evictList = list.New()
 	c.items = make(map[interface{}]*list.Element, c.size+1)
+	c.peakItems = c.size + 1
 }
 
 func (c *lruCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, err := c.serialize(key, value)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check for existing item
 	var item *cacheItem
 	if it, ok := c.items[key]; ok {
-		c.evictList.MoveToFront(it)
+		if c.setCountsAsAccess {
+			c.evictList.MoveToFront(it)
+		}
 		item = it.Value.(*cacheItem)
-		item.value = value
+		item.value = c.soft(value)
 	} else {
 		// Verify size not exceeded
-		if c.evictList.Len() >= c.size {
-			c.evict(1)
+		var ent *list.Element
+		if c.evictList.Len() >= c.size && c.evictionPauses == 0 {
+			if c.recycleNodes {
+				ent = c.evictForReuse()
+			} else {
+				c.evict(1)
+			}
 		}
-		item = &cacheItem{
-			clock: c.clock,
-			key:   key,
-			value: value,
+		if ent != nil {
+			item = ent.Value.(*cacheItem)
+			*item = cacheItem{
+				clock: c.clock,
+				key:   key,
+				value: c.soft(value),
+			}
+			c.evictList.MoveToFront(ent)
+		} else {
+			item = &cacheItem{
+				clock: c.clock,
+				key:   key,
+				value: c.soft(value),
+			}
+			ent = c.evictList.PushFront(item)
 		}
-		c.items[key] = c.evictList.PushFront(item)
+		c.stampInserted(item)
+		c.items[key] = ent
+		c.trackPeak(len(c.items))
+		c.stats.observeLen(len(c.items))
 	}
 
 	if c.expiration != nil {
 		t := c.clock.Now().Add(*c.expiration)
 		item.expiration = &t
 	}
+	item.setMaxIdle(c.maxIdle)
+	item.version = c.nextVersion()
 
-	if c.addedFunc != nil {
-		c.addedFunc(key, value)
-	}
+	c.notifyAdded(key, value)
 
+	c.recordSet(key)
 	return item, nil
 }
 
-// set a new key-value pair
-func (c *lruCache) Set(key, value interface{}) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	_, err := c.set(key, value)
-	return err
-}
-
 func (c *lruCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	v, err := c.getValue(key, onLoad)
 	if err != nil {
 		return nil, err
 	}
-	if c.deserializeFunc != nil {
-		return c.deserializeFunc(key, v)
+	v, err = c.deserialize(key, v)
+	if !onLoad {
+		c.recordGet(key, err == nil)
 	}
-	return v, nil
+	return v, err
 }
 
 func (c *lruCache) getValue(key interface{}, onLoad bool) (interface{}, error) {
@@ -90,42 +107,108 @@ func (c *lruCache) getValue(key interface{}, onLoad bool) (interface{}, error) {
 	item, ok := c.items[key]
 	if ok {
 		it := item.Value.(*cacheItem)
-		if !it.IsExpired(nil) {
+		if v, ok := it.liveValue(nil); ok {
+			c.touch(it)
 			c.evictList.MoveToFront(item)
-			v := it.value
-			c.mu.Unlock()
-			if !onLoad {
-				c.stats.IncrHitCount()
-			}
+			c.unlock()
 			return v, nil
 		}
-		c.removeElement(item)
+		if !c.lazyExpireDisabled {
+			c.removeElement(item)
+			c.notifyExpired(it.key, it.value)
+		}
 	}
-	c.mu.Unlock()
+	c.unlock()
 	if !onLoad {
-		c.stats.IncrMissCount()
+		c.recordGet(key, false)
 	}
 	return nil, ErrKeyNotFound
 }
 
 // evict removes the oldest item from the cache.
 func (c *lruCache) evict(count int) {
+	if c.evictExpiredFirst {
+		count -= c.evictExpired(count)
+	}
 	for i := 0; i < count; i++ {
 		ent := c.evictList.Back()
 		if ent == nil {
 			return
 		}
-		
+
+		c.recordVictim(ent.Value.(*cacheItem))
 		c.removeElement(ent)
 	}
 }
 
+// evictForReuse evicts an item like evict(1), an expired one first if
+// evictExpiredFirst is set, but leaves its node in the list, so that the
+// caller can reuse the node and the item for a new key. It returns nil if
+// there is no item.
+func (c *lruCache) evictForReuse() *list.Element {
+	if c.evictExpiredFirst {
+		if ent := c.oldestExpired(); ent != nil {
+			item := ent.Value.(*cacheItem)
+			c.detach(ent)
+			c.notifyExpired(item.key, item.value)
+			return ent
+		}
+	}
+	ent := c.evictList.Back()
+	if ent == nil {
+		return nil
+	}
+	c.recordVictim(ent.Value.(*cacheItem))
+	c.detach(ent)
+	return ent
+}
+
+// oldestExpired returns the node of the oldest expired item among the oldest
+// ones probed by evictExpired, or nil.
+func (c *lruCache) oldestExpired() *list.Element {
+	now := c.clock.Now()
+	ent := c.evictList.Back()
+	for i := 0; ent != nil && i < expiredProbeLimit; i++ {
+		if ent.Value.(*cacheItem).IsExpired(&now) {
+			return ent
+		}
+		ent = ent.Prev()
+	}
+	return nil
+}
+
+// evictOverflow evicts the items exceeding the size of the cache.
+func (c *lruCache) evictOverflow() {
+	if n := c.evictList.Len() - c.size; n > 0 {
+		c.evict(n)
+	}
+}
+
+// evictExpired removes up to count expired items among the oldest ones and
+// returns the number of items removed.
+func (c *lruCache) evictExpired(count int) int {
+	now := c.clock.Now()
+	removed := 0
+	ent := c.evictList.Back()
+	for i := 0; ent != nil && i < expiredProbeLimit && removed < count; i++ {
+		prev := ent.Prev()
+		if item := ent.Value.(*cacheItem); item.IsExpired(&now) {
+			c.removeElement(ent)
+			c.notifyExpired(item.key, item.value)
+			removed++
+		}
+		ent = prev
+	}
+	return removed
+}
+
 // Has checks if key exists in cache
 func (c *lruCache) Existed(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	now := time.Now()
-	return c.has(key, &now)
+	now := c.clock.Now()
+	return c.existed(c.has(key, &now))
 }
 
 func (c *lruCache) has(key interface{}, now *time.Time) bool {
@@ -136,12 +219,38 @@ func (c *lruCache) has(key interface{}, now *time.Time) bool {
 	return !item.Value.(*cacheItem).IsExpired(now)
 }
 
+// peek returns the item for key without updating the eviction order, or nil.
+func (c *lruCache) peek(key interface{}) *cacheItem {
+	if elt, ok := c.items[key]; ok {
+		return elt.Value.(*cacheItem)
+	}
+	return nil
+}
+
+// access records a read of the item for key, moving it to the front.
+func (c *lruCache) access(key interface{}) {
+	if elt, ok := c.items[key]; ok {
+		c.touch(elt.Value.(*cacheItem))
+		c.evictList.MoveToFront(elt)
+	}
+}
+
+// forEach calls fn for every item, most recently used first, until fn returns false.
+func (c *lruCache) forEach(fn func(item *cacheItem) bool) {
+	for e := c.evictList.Front(); e != nil; e = e.Next() {
+		if !fn(e.Value.(*cacheItem)) {
+			return
+		}
+	}
+}
+
 // Remove removes the provided key from the cache.
 func (c *lruCache) Remove(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	return c.remove(key)
+	return c.recordRemove(key, c.remove(key))
 }
 
 func (c *lruCache) remove(key interface{}) bool {
@@ -154,12 +263,14 @@ func (c *lruCache) remove(key interface{}) bool {
 
 func (c *lruCache) removeElement(e *list.Element) {
 	c.evictList.Remove(e)
+	c.detach(e)
+}
+
+// detach removes the item of e from the cache but leaves e in the list.
+func (c *lruCache) detach(e *list.Element) {
 	entry := e.Value.(*cacheItem)
 	delete(c.items, entry.key)
-	if c.evictedFunc != nil {
-		entry := e.Value.(*cacheItem)
-		c.evictedFunc(entry.key, entry.value)
-	}
+	c.notifyEvicted(entry.key, entry.value)
 }
 
 func (c *lruCache) keys() []interface{} {
@@ -174,29 +285,15 @@ func (c *lruCache) keys() []interface{} {
 	return keys
 }
 
-// GetALL returns all key-value pairs in the cache.
-func (c *lruCache) GetALL(checkExpired bool) map[interface{}]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	items := make(map[interface{}]interface{}, len(c.items))
-	now := time.Now()
-	for k, item := range c.items {
-		if !checkExpired || c.has(k, &now) {
-			items[k] = item.Value.(*cacheItem).value
-		}
-	}
-	return items
-}
-
 // Keys returns a slice of the keys in the cache.
 func (c *lruCache) Keys(checkExpired bool) []interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	keys := make([]interface{}, 0, len(c.items))
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if !checkExpired || c.has(k, &now) {
-			keys = append(keys, k)
+			keys = append(keys, c.decodeKey(k))
 		}
 	}
 	return keys
@@ -210,7 +307,7 @@ func (c *lruCache) Len(checkExpired bool) int {
 		return len(c.items)
 	}
 	var length int
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if c.has(k, &now) {
 			length++
@@ -219,18 +316,38 @@ func (c *lruCache) Len(checkExpired bool) int {
 	return length
 }
 
+// Compact rebuilds the items map if it has shrunk far below its peak size.
+// The recency list is left untouched, so the eviction order is preserved.
+func (c *lruCache) Compact() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.needCompact(len(c.items)) {
+		return
+	}
+	items := make(map[interface{}]*list.Element, len(c.items))
+	for k, elt := range c.items {
+		items[k] = elt
+	}
+	c.items = items
+	c.peakItems = len(items)
+}
+
 // Completely clear the cache
 func (c *lruCache) Purge() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	if c.purgeVisitorFunc != nil {
 		for key, item := range c.items {
 			it := item.Value.(*cacheItem)
 			v := it.value
-			c.purgeVisitorFunc(key, v)
+			c.notifyPurged(key, v)
 		}
 	}
 
+	c.tags = nil
+	c.order = insertionOrder{}
+	c.clearLoaderErrors()
 	c.init()
 }
@@ -2,6 +2,7 @@ package gcache
 
 import (
 	"fmt"
+	"sync"
 	"testing"
 	"time"
 )
@@ -79,3 +80,132 @@ func TestLRUHas(t *testing.T) {
 		})
 	}
 }
+
+func TestLRURecycleNodes(t *testing.T) {
+	evicted := make(map[interface{}]interface{})
+	gc := New(3).
+		LRU().
+		RecycleNodes(true).
+		EvictedFunc(func(key, value interface{}) {
+			evicted[key] = value
+		}).
+		Build()
+	for i := 0; i < 3; i++ {
+		gc.SetWithExpire(i, i*10, time.Hour)
+	}
+	gc.GetIFPresent(0)
+	gc.Set(3, 30)
+
+	if len(evicted) != 1 || evicted[1] != 10 {
+		t.Errorf("%v != %v", evicted, map[interface{}]interface{}{1: 10})
+	}
+	for key, expected := range map[interface{}]interface{}{0: 0, 2: 20, 3: 30} {
+		if v, err := gc.GetIFPresent(key); err != nil || v != expected {
+			t.Errorf("unexpected value %v, %v for key %v", v, err, key)
+		}
+	}
+	// The recycled item must not keep the expiration of the evicted one.
+	if e := gc.GetMultiWithExpiration([]interface{}{3})[3]; !e.ExpireAt.IsZero() {
+		t.Errorf("%v should not expire", e.ExpireAt)
+	}
+	if n := gc.Len(false); n != 3 {
+		t.Errorf("%v != %v", n, 3)
+	}
+}
+
+func TestLRURecycleExpiredNode(t *testing.T) {
+	fc := NewFakeClock()
+	var expired []interface{}
+	gc := New(3).
+		LRU().
+		Clock(fc).
+		RecycleNodes(true).
+		ExpiredFunc(func(key, value interface{}) {
+			expired = append(expired, key)
+		}).
+		Build()
+	gc.Set(0, 0)
+	gc.SetWithExpire(1, 1, time.Second)
+	gc.Set(2, 2)
+	c := gc.(*lruCache)
+	node := c.items[1]
+	fc.Advance(2 * time.Second)
+	gc.Set(3, 3)
+
+	if c.items[3] != node {
+		t.Error("the node of the expired item should be reused")
+	}
+	if fmt.Sprint(expired) != "[1]" {
+		t.Errorf("%v != %v", expired, []interface{}{1})
+	}
+	if n := c.evictList.Len(); n != 3 {
+		t.Errorf("%v != %v", n, 3)
+	}
+	for _, key := range []interface{}{0, 2, 3} {
+		if v, err := gc.GetIFPresent(key); err != nil || v != key {
+			t.Errorf("unexpected value %v, %v for key %v", v, err, key)
+		}
+	}
+}
+
+func TestLRURecycleNodesConcurrent(t *testing.T) {
+	gc := New(16).
+		LRU().
+		RecycleNodes(true).
+		EvictedFunc(func(key, value interface{}) {
+			if key != value {
+				t.Errorf("evicted key %v with value %v", key, value)
+			}
+		}).
+		Build()
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 1000; i++ {
+				key := (g*1000 + i) % 64
+				switch i % 3 {
+				case 0, 1:
+					gc.Set(key, key)
+				case 2:
+					if v, err := gc.GetIFPresent(key); err == nil && v != key {
+						t.Errorf("key %v has value %v", key, v)
+					}
+				}
+				if i%50 == 0 {
+					gc.Remove(key)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	if n := gc.Len(false); n > 16 {
+		t.Errorf("%v > %v", n, 16)
+	}
+}
+
+func BenchmarkLRUChurn(b *testing.B) {
+	for _, recycle := range []bool{false, true} {
+		b.Run(fmt.Sprintf("recycle=%v", recycle), func(b *testing.B) {
+			const size = 1000
+			gc := New(size).LRU().RecycleNodes(recycle).Build()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				gc.Set(i, i)
+			}
+		})
+		// Removed nodes are not recycled, so this path gains nothing.
+		b.Run(fmt.Sprintf("remove/recycle=%v", recycle), func(b *testing.B) {
+			const size = 1000
+			gc := New(size).LRU().RecycleNodes(recycle).Build()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				gc.Set(i, i)
+				gc.Remove(i - size/2)
+			}
+		})
+	}
+}
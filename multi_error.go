@@ -0,0 +1,79 @@
+package gcache
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// MultiError is returned by batch operations which fail for some of their keys.
+// It maps each failed key to its error.
+type MultiError struct {
+	errs map[interface{}]error
+}
+
+// add records err for key.
+func (me *MultiError) add(key interface{}, err error) {
+	if me.errs == nil {
+		me.errs = make(map[interface{}]error)
+	}
+	me.errs[key] = err
+}
+
+// errOrNil returns me if it holds any errors, or nil otherwise.
+func (me *MultiError) errOrNil() error {
+	if me == nil || len(me.errs) == 0 {
+		return nil
+	}
+	return me
+}
+
+// Errors returns the error of every failed key.
+func (me *MultiError) Errors() map[interface{}]error {
+	errs := make(map[interface{}]error, len(me.errs))
+	for k, err := range me.errs {
+		errs[k] = err
+	}
+	return errs
+}
+
+func (me *MultiError) Error() string {
+	msgs := make([]string, 0, len(me.errs))
+	for k, err := range me.errs {
+		msgs = append(msgs, fmt.Sprintf("%v: %v", k, err))
+	}
+	sort.Strings(msgs)
+	return fmt.Sprintf("gcache: %d keys failed: %s", len(msgs), strings.Join(msgs, "; "))
+}
+
+// Unwrap returns the contained errors.
+func (me *MultiError) Unwrap() []error {
+	errs := make([]error, 0, len(me.errs))
+	for _, err := range me.errs {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// Is reports whether any of the contained errors matches target.
+// It lets errors.Is look into a MultiError before Go 1.20.
+func (me *MultiError) Is(target error) bool {
+	for _, err := range me.errs {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first contained error that matches target.
+// It lets errors.As look into a MultiError before Go 1.20.
+func (me *MultiError) As(target interface{}) bool {
+	for _, err := range me.errs {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+package gcache
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type keyError struct {
+	key interface{}
+}
+
+func (e *keyError) Error() string {
+	return fmt.Sprintf("bad key %v", e.key)
+}
+
+func TestMultiError(t *testing.T) {
+	me := &MultiError{}
+	if err := me.errOrNil(); err != nil {
+		t.Fatalf("%v != nil", err)
+	}
+
+	errBackend := errors.New("backend down")
+	me.add("a", fmt.Errorf("load a: %w", errBackend))
+	me.add("b", &keyError{key: "b"})
+	err := me.errOrNil()
+	if err == nil {
+		t.Fatal("err should not be nil")
+	}
+
+	errs := me.Errors()
+	if len(errs) != 2 {
+		t.Fatalf("%v != %v", len(errs), 2)
+	}
+	if !errors.Is(errs["a"], errBackend) {
+		t.Errorf("%v should wrap %v", errs["a"], errBackend)
+	}
+	if !errors.Is(err, errBackend) {
+		t.Errorf("%v should match %v", err, errBackend)
+	}
+	if errors.Is(err, ErrKeyNotFound) {
+		t.Errorf("%v should not match %v", err, ErrKeyNotFound)
+	}
+	var ke *keyError
+	if !errors.As(err, &ke) || ke.key != "b" {
+		t.Errorf("%v should contain a keyError for b", err)
+	}
+	expected := "gcache: 2 keys failed: a: load a: backend down; b: bad key b"
+	if err.Error() != expected {
+		t.Errorf("%v != %v", err.Error(), expected)
+	}
+}
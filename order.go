@@ -0,0 +1,91 @@
+package gcache
+
+import "sort"
+
+// minOrderTrim is the smallest number of entries at which insertionOrder is
+// trimmed.
+const minOrderTrim = 64
+
+// insertionOrder lists the keys of a cache in the order they were inserted.
+// Removed keys stay in it until they are popped or trimmed; an entry is live
+// while the item of its key still has its seq.
+type insertionOrder struct {
+	entries []orderEntry
+	start   int
+	// trimAt is the number of entries at which the removed ones are trimmed.
+	trimAt int
+}
+
+type orderEntry struct {
+	seq uint64
+	key interface{}
+}
+
+// search returns the index of the first entry with a seq above seq.
+func (o *insertionOrder) search(seq uint64) int {
+	return o.start + sort.Search(len(o.entries)-o.start, func(i int) bool {
+		return o.entries[o.start+i].seq > seq
+	})
+}
+
+// orderedItem returns the item of e, or nil if e was removed.
+func (c *baseCache) orderedItem(e orderEntry) *cacheItem {
+	if item := c.cache.peek(e.key); item != nil && item.seq == e.seq {
+		return item
+	}
+	return nil
+}
+
+// appendOrder adds item, which is being inserted, at the end of the order.
+// It must be called with mu held.
+func (c *baseCache) appendOrder(item *cacheItem) {
+	o := &c.order
+	if len(o.entries)-o.start >= o.trimAt {
+		entries := make([]orderEntry, 0, len(o.entries)-o.start+1)
+		for _, e := range o.entries[o.start:] {
+			if c.orderedItem(e) != nil {
+				entries = append(entries, e)
+			}
+		}
+		o.entries, o.start = entries, 0
+		o.trimAt = maxInt(2*len(entries), minOrderTrim)
+	}
+	o.entries = append(o.entries, orderEntry{item.seq, item.key})
+}
+
+// popOldest removes the item inserted first from the order and returns it,
+// or nil if there is none. It must be called with mu held.
+func (c *baseCache) popOldest() *cacheItem {
+	o := &c.order
+	for o.start < len(o.entries) {
+		e := o.entries[o.start]
+		o.entries[o.start] = orderEntry{}
+		o.start++
+		if item := c.orderedItem(e); item != nil {
+			return item
+		}
+	}
+	return nil
+}
+
+// KeysPage returns up to limit keys inserted after the one cursor refers to.
+func (c *baseCache) KeysPage(checkExpired bool, cursor uint64, limit int) ([]interface{}, uint64, int) {
+	total := c.cache.Len(false)
+	var page []interface{}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	now := c.clock.Now()
+	o := &c.order
+	for i := o.search(cursor); i < len(o.entries); i++ {
+		if len(page) >= limit {
+			return page, cursor, total
+		}
+		item := c.orderedItem(o.entries[i])
+		if item == nil || checkExpired && item.IsExpired(&now) {
+			continue
+		}
+		page = append(page, c.decodeKey(item.key))
+		cursor = item.seq
+	}
+	return page, 0, total
+}
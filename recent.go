@@ -0,0 +1,52 @@
+package gcache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// recentKeys tracks the most recently set distinct keys, up to a fixed size.
+// Like auditLog it has its own mutex so it never contends with the cache lock.
+type recentKeys struct {
+	mu    sync.Mutex
+	size  int
+	order *list.List // most recent first
+	elems map[interface{}]*list.Element
+}
+
+func newRecentKeys(size int) *recentKeys {
+	return &recentKeys{
+		size:  size,
+		order: list.New(),
+		elems: make(map[interface{}]*list.Element, size),
+	}
+}
+
+func (rk *recentKeys) record(key interface{}) {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	if e, ok := rk.elems[key]; ok {
+		rk.order.MoveToFront(e)
+		return
+	}
+	rk.elems[key] = rk.order.PushFront(key)
+	if rk.order.Len() > rk.size {
+		e := rk.order.Back()
+		rk.order.Remove(e)
+		delete(rk.elems, e.Value)
+	}
+}
+
+// latest returns up to n keys, most recent first.
+func (rk *recentKeys) latest(n int) []interface{} {
+	rk.mu.Lock()
+	defer rk.mu.Unlock()
+	if n > rk.order.Len() {
+		n = rk.order.Len()
+	}
+	keys := make([]interface{}, 0, n)
+	for e := rk.order.Front(); e != nil && len(keys) < n; e = e.Next() {
+		keys = append(keys, e.Value)
+	}
+	return keys
+}
@@ -0,0 +1,36 @@
+package gcache
+
+import (
+	"testing"
+)
+
+func TestRecentlySet(t *testing.T) {
+	cc := New(8).
+		LFU().
+		TrackRecentlySet(3).
+		Build()
+	if keys := cc.RecentlySet(3); len(keys) != 0 {
+		t.Fatalf("%v != %v", len(keys), 0)
+	}
+
+	for _, key := range []string{"a", "b", "c", "d", "b", "b"} {
+		cc.Set(key, key)
+	}
+	expected := []interface{}{"b", "d", "c"}
+	keys := cc.RecentlySet(5)
+	if len(keys) != len(expected) {
+		t.Fatalf("%v != %v", keys, expected)
+	}
+	for i, key := range keys {
+		if key != expected[i] {
+			t.Errorf("keys[%d]: %v != %v", i, key, expected[i])
+		}
+	}
+	if keys := cc.RecentlySet(1); len(keys) != 1 || keys[0] != "b" {
+		t.Errorf("%v != [b]", keys)
+	}
+
+	if keys := New(8).Build().RecentlySet(3); keys != nil {
+		t.Errorf("%v != nil", keys)
+	}
+}
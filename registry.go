@@ -0,0 +1,94 @@
+package gcache
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ErrEvictTypeRegistered is returned by RegisterEvictType for a name which is
+// already registered.
+var ErrEvictTypeRegistered = errors.New("gcache: evict type already registered")
+
+// EvictionPolicy chooses the items evicted from a cache of an eviction type
+// registered with RegisterEvictType. The cache calls it with its lock held,
+// so it must not call back into the cache. The keys are the stored keys, i.e.
+// encoded with the KeyCodec if there is one.
+type EvictionPolicy interface {
+	// Add is called when key is inserted into the cache.
+	Add(key interface{})
+	// Access is called when key is read, or set while present if sets count
+	// as accesses, see SetCountsAsAccess.
+	Access(key interface{})
+	// Remove is called when key leaves the cache, including when it is
+	// evicted as a victim.
+	Remove(key interface{})
+	// Victim returns the key to evict next, which must be in the cache, or
+	// false if there is none.
+	Victim() (key interface{}, ok bool)
+}
+
+// EvictTypeFactory returns the eviction policy of a new cache of an eviction
+// type registered with RegisterEvictType. It is passed the builder the cache
+// is built from.
+type EvictTypeFactory func(cb *CacheBuilder) EvictionPolicy
+
+// cacheFactory builds a cache of an eviction type.
+type cacheFactory func(cb *CacheBuilder) LoadingCache
+
+var evictTypes = struct {
+	sync.RWMutex
+	factories map[string]cacheFactory
+}{
+	factories: map[string]cacheFactory{
+		TypeSimple: func(cb *CacheBuilder) LoadingCache { return newSimpleCache(cb) },
+		TypeLru:    func(cb *CacheBuilder) LoadingCache { return newLRUCache(cb) },
+		TypeLfu:    func(cb *CacheBuilder) LoadingCache { return newLFUCache(cb) },
+		TypeArc:    func(cb *CacheBuilder) LoadingCache { return newARC(cb) },
+	},
+}
+
+// RegisterEvictType registers factory as the eviction type name, to be used
+// with EvictType. It returns ErrEvictTypeRegistered if name is taken,
+// which includes the built-in types.
+func RegisterEvictType(name string, factory EvictTypeFactory) error {
+	evictTypes.Lock()
+	defer evictTypes.Unlock()
+	if _, ok := evictTypes.factories[name]; ok {
+		return fmt.Errorf("%w: %q", ErrEvictTypeRegistered, name)
+	}
+	evictTypes.factories[name] = policyCacheFactory(factory)
+	return nil
+}
+
+// MustRegisterEvictType is like RegisterEvictType but panics on error.
+func MustRegisterEvictType(name string, factory EvictTypeFactory) {
+	if err := RegisterEvictType(name, factory); err != nil {
+		panic(err)
+	}
+}
+
+// OverrideEvictType registers factory as the eviction type name, replacing
+// any factory already registered for it, including a built-in one.
+func OverrideEvictType(name string, factory EvictTypeFactory) {
+	evictTypes.Lock()
+	defer evictTypes.Unlock()
+	evictTypes.factories[name] = policyCacheFactory(factory)
+}
+
+// policyCacheFactory builds caches whose items are evicted by the policy
+// returned by factory.
+func policyCacheFactory(factory EvictTypeFactory) cacheFactory {
+	return func(cb *CacheBuilder) LoadingCache {
+		c := newSimpleCache(cb)
+		c.policy = factory(cb)
+		return c
+	}
+}
+
+func lookupEvictType(name string) (cacheFactory, bool) {
+	evictTypes.RLock()
+	defer evictTypes.RUnlock()
+	factory, ok := evictTypes.factories[name]
+	return factory, ok
+}
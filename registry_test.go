@@ -0,0 +1,176 @@
+package gcache
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func unregisterEvictType(name string) {
+	evictTypes.Lock()
+	defer evictTypes.Unlock()
+	delete(evictTypes.factories, name)
+}
+
+// fifoPolicy evicts the keys in insertion order, using only the exported API.
+type fifoPolicy struct {
+	keys []interface{}
+}
+
+func (p *fifoPolicy) Add(key interface{})    { p.keys = append(p.keys, key) }
+func (p *fifoPolicy) Access(key interface{}) {}
+
+func (p *fifoPolicy) Remove(key interface{}) {
+	for i, k := range p.keys {
+		if k == key {
+			p.keys = append(p.keys[:i], p.keys[i+1:]...)
+			return
+		}
+	}
+}
+
+func (p *fifoPolicy) Victim() (interface{}, bool) {
+	if len(p.keys) == 0 {
+		return nil, false
+	}
+	return p.keys[0], true
+}
+
+func TestRegisterEvictType(t *testing.T) {
+	defer unregisterEvictType("fifo")
+	factory := func(cb *CacheBuilder) EvictionPolicy {
+		return &fifoPolicy{}
+	}
+	if err := RegisterEvictType("fifo", factory); err != nil {
+		t.Fatal(err)
+	}
+	var evicted []interface{}
+	gc := New(2).
+		EvictType("fifo").
+		EvictedFunc(func(key, value interface{}) {
+			evicted = append(evicted, key)
+		}).
+		Build()
+	if tp := gc.Type(); tp != "fifo" {
+		t.Errorf("%v != %v", tp, "fifo")
+	}
+	gc.Set(0, 0)
+	gc.Set(1, 1)
+	gc.GetIFPresent(0)
+	gc.Remove(1)
+	gc.Set(2, 2)
+	gc.Set(3, 3)
+	gc.Set(4, 4)
+	if expected := []interface{}{1, 0, 2}; !reflect.DeepEqual(evicted, expected) {
+		t.Errorf("%v != %v", evicted, expected)
+	}
+	if l := gc.Len(false); l != 2 {
+		t.Errorf("%v != %v", l, 2)
+	}
+	gc.Purge()
+	gc.Set(5, 5)
+	gc.Set(6, 6)
+	gc.Set(7, 7)
+	if gc.Existed(5) || !gc.Existed(6) || !gc.Existed(7) {
+		t.Errorf("unexpected keys %v", gc.Keys(false))
+	}
+
+	if err := RegisterEvictType("fifo", factory); !errors.Is(err, ErrEvictTypeRegistered) {
+		t.Errorf("%v != %v", err, ErrEvictTypeRegistered)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("MustRegisterEvictType should panic on a duplicate")
+			}
+		}()
+		MustRegisterEvictType("fifo", factory)
+	}()
+}
+
+func TestRegisterEvictTypeBuiltin(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		if err := RegisterEvictType(tp, nil); !errors.Is(err, ErrEvictTypeRegistered) {
+			t.Errorf("%v: %v != %v", tp, err, ErrEvictTypeRegistered)
+		}
+		if gc := New(8).EvictType(tp).Build(); gc.Type() != tp {
+			t.Errorf("%v != %v", gc.Type(), tp)
+		}
+	}
+}
+
+func TestOverrideEvictType(t *testing.T) {
+	builtin, _ := lookupEvictType(TypeSimple)
+	defer func() {
+		evictTypes.Lock()
+		defer evictTypes.Unlock()
+		evictTypes.factories[TypeSimple] = builtin
+	}()
+
+	var built int
+	OverrideEvictType(TypeSimple, func(cb *CacheBuilder) EvictionPolicy {
+		built++
+		return &fifoPolicy{}
+	})
+	gc := New(2).Build()
+	if built != 1 {
+		t.Errorf("%v != %v", built, 1)
+	}
+	for i := 0; i < 3; i++ {
+		gc.Set(i, i)
+	}
+	if gc.Existed(0) {
+		t.Error("the overriding policy should evict the first key")
+	}
+}
+
+// countingPolicy is a fifoPolicy which counts the accesses.
+type countingPolicy struct {
+	fifoPolicy
+	accesses int
+}
+
+func (p *countingPolicy) Access(key interface{}) { p.accesses++ }
+
+func TestOverrideBuiltinEvictType(t *testing.T) {
+	for _, tp := range []string{TypeLru, TypeLfu} {
+		t.Run(tp, func(t *testing.T) {
+			builtin, _ := lookupEvictType(tp)
+			defer func() {
+				evictTypes.Lock()
+				defer evictTypes.Unlock()
+				evictTypes.factories[tp] = builtin
+			}()
+
+			policy := &countingPolicy{}
+			OverrideEvictType(tp, func(cb *CacheBuilder) EvictionPolicy {
+				return policy
+			})
+			gc := New(4).EvictType(tp).Build()
+			gc.Set("a", 1)
+			gc.Set("b", 2)
+			gc.Set("c", 3)
+			// Sets do not count as accesses, unlike in the built-in LRU cache.
+			gc.Set("a", 1)
+			if policy.accesses != 0 {
+				t.Errorf("%v != %v", policy.accesses, 0)
+			}
+
+			old, existed, err := gc.Swap("b", 20)
+			if err != nil || !existed || old != 2 {
+				t.Errorf("unexpected result %v, %v, %v", old, existed, err)
+			}
+			if v, _ := gc.GetIFPresent("b"); v != 20 {
+				t.Errorf("%v != %v", v, 20)
+			}
+
+			page, next, total := gc.KeysPage(false, 0, 10)
+			if expected := []interface{}{"a", "b", "c"}; !reflect.DeepEqual(page, expected) {
+				t.Errorf("%v != %v", page, expected)
+			}
+			if next != 0 || total != 3 {
+				t.Errorf("%v, %v != 0, 3", next, total)
+			}
+		})
+	}
+}
@@ -4,10 +4,15 @@ import (
 	"time"
 )
 
-// simpleCache has no clear priority for evict cache. It depends on key-value map order.
+// simpleCache has no clear priority for evict cache. Once the expired items
+// are gone, it evicts the items in the order they were inserted.
 type simpleCache struct {
 	baseCache
 	items map[interface{}]*cacheItem
+
+	// policy chooses the evicted items of a registered eviction type, or is
+	// nil for TypeSimple.
+	policy EvictionPolicy
 }
 
 func newSimpleCache(cb *CacheBuilder) *simpleCache {
@@ -25,42 +30,51 @@ func (c *simpleCache) init() {
 	} else {
 		c.items = make(map[interface{}]*cacheItem, c.size)
 	}
+	c.peakItems = c.size
 }
 
 func (c *simpleCache) set(key, value interface{}) (interface{}, error) {
-	var err error
-	if c.serializeFunc != nil {
-		value, err = c.serializeFunc(key, value)
-		if err != nil {
-			return nil, err
-		}
+	value, err := c.serialize(key, value)
+	if err != nil {
+		return nil, err
 	}
 
 	// Check for existing item
 	item, ok := c.items[key]
 	if ok {
-		item.value = value
+		item.value = c.soft(value)
+		if c.policy != nil && c.setCountsAsAccess {
+			c.policy.Access(key)
+		}
 	} else {
 		// Verify size not exceeded
-		if (len(c.items) >= c.size) && c.size > 0 {
+		if (len(c.items) >= c.size) && c.size > 0 && c.evictionPauses == 0 {
 			c.evict(1)
 		}
 		item = &cacheItem{
 			clock: c.clock,
-			value: value,
+			key:   key,
+			value: c.soft(value),
 		}
+		c.stampInserted(item)
 		c.items[key] = item
+		if c.policy != nil {
+			c.policy.Add(key)
+		}
+		c.trackPeak(len(c.items))
+		c.stats.observeLen(len(c.items))
 	}
 
 	if c.expiration != nil {
 		t := c.clock.Now().Add(*c.expiration)
 		item.expiration = &t
 	}
+	item.setMaxIdle(c.maxIdle)
+	item.version = c.nextVersion()
 
-	if c.addedFunc != nil {
-		c.addedFunc(key, value)
-	}
+	c.notifyAdded(key, value)
 
+	c.recordSet(key)
 	return item, nil
 }
 
@@ -69,53 +83,111 @@ func (c *simpleCache) get(key interface{}, onLoad bool) (interface{}, error) {
 	if err != nil {
 		return nil, err
 	}
-	if c.deserializeFunc != nil {
-		return c.deserializeFunc(key, v)
+	v, err = c.deserialize(key, v)
+	if !onLoad {
+		c.recordGet(key, err == nil)
 	}
-	return v, nil
+	return v, err
 }
 
 func (c *simpleCache) getValue(key interface{}, onLoad bool) (interface{}, error) {
 	c.mu.Lock()
 	item, ok := c.items[key]
 	if ok {
-		if !item.IsExpired(nil) {
-			v := item.value
-			c.mu.Unlock()
-			if !onLoad {
-				c.stats.IncrHitCount()
+		if v, ok := item.liveValue(nil); ok {
+			c.touch(item)
+			if c.policy != nil {
+				c.policy.Access(key)
 			}
+			c.unlock()
 			return v, nil
 		}
-		c.remove(key)
+		if !c.lazyExpireDisabled {
+			c.remove(key)
+			c.notifyExpired(key, item.value)
+		}
 	}
-	c.mu.Unlock()
+	c.unlock()
 	if !onLoad {
-		c.stats.IncrMissCount()
+		c.recordGet(key, false)
 	}
 	return nil, ErrKeyNotFound
 }
 
+// evict removes count items, the expired ones first if evictExpiredFirst is
+// set, then the victims of the policy of a registered eviction type, or the
+// oldest ones. Setting an existing key does not change its place in the order.
 func (c *simpleCache) evict(count int) {
-	now := c.clock.Now()
 	current := 0
-	for key, item := range c.items {
-		if current >= count {
+	if c.evictExpiredFirst {
+		now := c.clock.Now()
+		current = c.evictExpired(count, &now)
+	}
+	if c.policy != nil {
+		c.evictVictims(count - current)
+		return
+	}
+	c.evictOldest(count - current)
+}
+
+// evictOldest removes the count items inserted first.
+func (c *simpleCache) evictOldest(count int) {
+	for i := 0; i < count; i++ {
+		item := c.popOldest()
+		if item == nil {
 			return
 		}
-		if item.expiration == nil || now.After(*item.expiration) {
-			defer c.remove(key)
-			current++
+		c.recordVictim(item)
+		c.remove(item.key)
+	}
+}
+
+// evictVictims removes the count next victims of the eviction policy.
+func (c *simpleCache) evictVictims(count int) {
+	for i := 0; i < count; i++ {
+		key, ok := c.policy.Victim()
+		if !ok {
+			return
 		}
+		if item, ok := c.items[key]; ok {
+			c.recordVictim(item)
+			c.remove(key)
+		}
+	}
+}
+
+// evictOverflow evicts the items exceeding the size of the cache.
+func (c *simpleCache) evictOverflow() {
+	if n := len(c.items) - c.size; n > 0 && c.size > 0 {
+		c.evict(n)
 	}
 }
 
+// evictExpired removes up to count expired items among a few arbitrary ones
+// and returns the number of items removed.
+func (c *simpleCache) evictExpired(count int, now *time.Time) int {
+	removed, probed := 0, 0
+	for key, item := range c.items {
+		if probed >= expiredProbeLimit || removed >= count {
+			break
+		}
+		probed++
+		if item.IsExpired(now) {
+			c.remove(key)
+			c.notifyExpired(key, item.value)
+			removed++
+		}
+	}
+	return removed
+}
+
 // Has checks if key exists in cache
 func (c *simpleCache) Existed(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.RLock()
 	defer c.mu.RUnlock()
-	now := time.Now()
-	return c.has(key, &now)
+	now := c.clock.Now()
+	return c.existed(c.has(key, &now))
 }
 
 func (c *simpleCache) has(key interface{}, now *time.Time) bool {
@@ -126,21 +198,47 @@ func (c *simpleCache) has(key interface{}, now *time.Time) bool {
 	return !item.IsExpired(now)
 }
 
+// peek returns the item for key without updating the eviction order, or nil.
+func (c *simpleCache) peek(key interface{}) *cacheItem {
+	return c.items[key]
+}
+
+// access records a read of the item for key.
+func (c *simpleCache) access(key interface{}) {
+	if item, ok := c.items[key]; ok {
+		c.touch(item)
+		if c.policy != nil {
+			c.policy.Access(key)
+		}
+	}
+}
+
+// forEach calls fn for every item until fn returns false.
+func (c *simpleCache) forEach(fn func(item *cacheItem) bool) {
+	for _, item := range c.items {
+		if !fn(item) {
+			return
+		}
+	}
+}
+
 // Remove removes the provided key from the cache.
 func (c *simpleCache) Remove(key interface{}) bool {
+	key = c.encodeKey(key)
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
-	return c.remove(key)
+	return c.recordRemove(key, c.remove(key))
 }
 
 func (c *simpleCache) remove(key interface{}) bool {
 	item, ok := c.items[key]
 	if ok {
 		delete(c.items, key)
-		if c.evictedFunc != nil {
-			c.evictedFunc(key, item.value)
+		if c.policy != nil {
+			c.policy.Remove(key)
 		}
+		c.notifyEvicted(key, item.value)
 		return true
 	}
 	return false
@@ -159,29 +257,15 @@ func (c *simpleCache) keys() []interface{} {
 	return keys
 }
 
-// GetALL returns all key-value pairs in the cache.
-func (c *simpleCache) GetALL(checkExpired bool) map[interface{}]interface{} {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	items := make(map[interface{}]interface{}, len(c.items))
-	now := time.Now()
-	for k, item := range c.items {
-		if !checkExpired || c.has(k, &now) {
-			items[k] = item.value
-		}
-	}
-	return items
-}
-
 // Keys returns a slice of the keys in the cache.
 func (c *simpleCache) Keys(checkExpired bool) []interface{} {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	keys := make([]interface{}, 0, len(c.items))
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if !checkExpired || c.has(k, &now) {
-			keys = append(keys, k)
+			keys = append(keys, c.decodeKey(k))
 		}
 	}
 	return keys
@@ -195,7 +279,7 @@ func (c *simpleCache) Len(checkExpired bool) int {
 		return len(c.items)
 	}
 	var length int
-	now := time.Now()
+	now := c.clock.Now()
 	for k := range c.items {
 		if c.has(k, &now) {
 			length++
@@ -204,15 +288,39 @@ func (c *simpleCache) Len(checkExpired bool) int {
 	return length
 }
 
+// Compact rebuilds the items map if it has shrunk far below its peak size.
+func (c *simpleCache) Compact() {
+	c.mu.Lock()
+	defer c.unlock()
+
+	if !c.needCompact(len(c.items)) {
+		return
+	}
+	items := make(map[interface{}]*cacheItem, len(c.items))
+	for k, item := range c.items {
+		items[k] = item
+	}
+	c.items = items
+	c.peakItems = len(items)
+}
+
 // Completely clear the cache
 func (c *simpleCache) Purge() {
 	c.mu.Lock()
-	defer c.mu.Unlock()
+	defer c.unlock()
 
 	if c.purgeVisitorFunc != nil {
 		for key, item := range c.items {
-			c.purgeVisitorFunc(key, item.value)
+			c.notifyPurged(key, item.value)
+		}
+	}
+	if c.policy != nil {
+		for key := range c.items {
+			c.policy.Remove(key)
 		}
 	}
+	c.tags = nil
+	c.order = insertionOrder{}
+	c.clearLoaderErrors()
 	c.init()
 }
@@ -43,6 +43,19 @@ func TestSimpleEvictItem(t *testing.T) {
 	}
 }
 
+func TestSimpleEvictUnexpired(t *testing.T) {
+	gc := New(10).Simple().Expiration(time.Hour).Build()
+	for i := 0; i < 100; i++ {
+		gc.Set(i, i)
+		if l := gc.Len(false); l > 10 {
+			t.Fatalf("%v > %v", l, 10)
+		}
+	}
+	if l := gc.Len(false); l != 10 {
+		t.Errorf("%v != %v", l, 10)
+	}
+}
+
 func TestSimpleUnboundedNoEviction(t *testing.T) {
 	numbers := 1000
 	sizeTracker := 0
@@ -99,3 +112,50 @@ func TestSimpleHas(t *testing.T) {
 		})
 	}
 }
+
+func TestSimpleInsertionOrder(t *testing.T) {
+	const size = 4
+	var evicted []interface{}
+	gc := New(size).
+		Simple().
+		EvictedFunc(func(key, value interface{}) {
+			evicted = append(evicted, key)
+		}).
+		Build()
+	for i := 0; i < size; i++ {
+		gc.Set(i, i)
+	}
+	// Neither reads nor updates change the insertion order.
+	gc.GetIFPresent(0)
+	gc.Set(0, 10)
+	// A removed and re-inserted key goes to the back.
+	gc.Remove(1)
+	gc.Set(1, 1)
+	evicted = nil
+
+	for i := size; i < 2*size; i++ {
+		gc.Set(i, i)
+	}
+	expected := []interface{}{0, 2, 3, 1}
+	if fmt.Sprint(evicted) != fmt.Sprint(expected) {
+		t.Errorf("%v != %v", evicted, expected)
+	}
+	if n := gc.Len(false); n != size {
+		t.Errorf("%v != %v", n, size)
+	}
+
+	// Churn keeps the order bounded.
+	c := gc.(*simpleCache)
+	for i := 0; i < 1000; i++ {
+		gc.Set(i%7, i)
+		if i%3 == 0 {
+			gc.Remove(i % 7)
+		}
+	}
+	if n := len(c.order.entries) - c.order.start; n > minOrderTrim {
+		t.Errorf("order holds %v items", n)
+	}
+	if n := gc.Len(false); n > size {
+		t.Errorf("%v > %v", n, size)
+	}
+}
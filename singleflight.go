@@ -41,12 +41,16 @@ type Group struct {
 // time. If a duplicate comes in, the duplicate caller waits for the
 // original to complete and receives the same results.
 func (g *Group) Do(key interface{}, fn func() (interface{}, error), isWait bool) (interface{}, bool, error) {
+	return g.do(key, fn, isWait, false)
+}
+
+// DoFresh works like Do, but calls fn even if key is cached.
+func (g *Group) DoFresh(key interface{}, fn func() (interface{}, error)) (interface{}, bool, error) {
+	return g.do(key, fn, true, true)
+}
+
+func (g *Group) do(key interface{}, fn func() (interface{}, error), isWait, fresh bool) (interface{}, bool, error) {
 	g.mu.Lock()
-	v, err := g.cache.get(key, true)
-	if err == nil {
-		g.mu.Unlock()
-		return v, false, nil
-	}
 	if g.m == nil {
 		g.m = make(map[interface{}]*call)
 	}
@@ -62,21 +66,33 @@ func (g *Group) Do(key interface{}, fn func() (interface{}, error), isWait bool)
 	c.wg.Add(1)
 	g.m[key] = c
 	g.mu.Unlock()
+	if !fresh {
+		// The cache is checked without g.mu held, since dropping an expired
+		// item runs callbacks which may use the group. A call which finished
+		// before c was registered has stored its value by now.
+		if v, err := g.cache.get(key, true); err == nil {
+			g.call(c, key, func() (interface{}, error) { return v, nil })
+			return v, false, nil
+		}
+	}
 	if !isWait {
 		go g.call(c, key, fn)
 		return nil, false, ErrKeyNotFound
 	}
-	v, err = g.call(c, key, fn)
+	v, err := g.call(c, key, fn)
 	return v, true, err
 }
 
+// call runs fn for c. It finishes c even if fn panics, so that the callers
+// waiting for it and the later calls for key do not hang.
 func (g *Group) call(c *call, key interface{}, fn func() (interface{}, error)) (interface{}, error) {
-	c.val, c.err = fn()
-	c.wg.Done()
-
-	g.mu.Lock()
-	delete(g.m, key)
-	g.mu.Unlock()
+	defer func() {
+		c.wg.Done()
 
+		g.mu.Lock()
+		delete(g.m, key)
+		g.mu.Unlock()
+	}()
+	c.val, c.err = fn()
 	return c.val, c.err
 }
@@ -17,6 +17,7 @@ limitations under the License.
 */
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -86,3 +87,66 @@ func TestDoDupSuppress(t *testing.T) {
 		t.Errorf("number of calls = %d; want 1", got)
 	}
 }
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	g.cache = New(32).Build()
+	func() {
+		defer func() { recover() }()
+		g.Do("key", func() (interface{}, error) {
+			panic("boom")
+		}, true)
+	}()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		v, _, err := g.Do("key", func() (interface{}, error) {
+			return "bar", nil
+		}, true)
+		if v != "bar" || err != nil {
+			t.Errorf("%v, %v != bar, nil", v, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Do hangs after a panic")
+	}
+}
+
+func TestDoCallbackUsesGroup(t *testing.T) {
+	fc := NewFakeClock()
+	var gc Cache
+	gc = New(8).
+		Clock(fc).
+		LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+			return "loaded", nil
+		}).
+		ExpiredFunc(func(key, value interface{}) {
+			// Loads through the group which dropped the expired item.
+			gc.(LoadingCache).Get(context.Background(), "other")
+		}).
+		Build()
+	gc.SetWithExpire("key", "old", time.Second)
+	fc.Advance(2 * time.Second)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		g := &gc.(*simpleCache).loadGroup
+		v, _, err := g.Do("key", func() (interface{}, error) {
+			return "new", nil
+		}, true)
+		if err != nil || v != "new" {
+			t.Errorf("unexpected value %v, %v", v, err)
+		}
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Do deadlocks when a callback uses the group")
+	}
+	if v, _ := gc.GetIFPresent("other"); v != "loaded" {
+		t.Errorf("%v != %v", v, "loaded")
+	}
+}
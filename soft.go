@@ -0,0 +1,38 @@
+//go:build go1.24
+// +build go1.24
+
+package gcache
+
+import (
+	"reflect"
+	"unsafe"
+	"weak"
+)
+
+// softValue references a pointer value weakly, so that it can be reclaimed
+// by the garbage collector once nothing outside the cache references it.
+type softValue struct {
+	typ reflect.Type
+	ref weak.Pointer[byte]
+}
+
+// soften wraps pointer values in a softValue. Other values are returned as is.
+func soften(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Type().Elem().Size() == 0 {
+		return v
+	}
+	return &softValue{
+		typ: rv.Type(),
+		ref: weak.Make((*byte)(rv.UnsafePointer())),
+	}
+}
+
+// value returns the referenced value, or false if it has been reclaimed.
+func (sv *softValue) value() (interface{}, bool) {
+	p := sv.ref.Value()
+	if p == nil {
+		return nil, false
+	}
+	return reflect.NewAt(sv.typ.Elem(), unsafe.Pointer(p)).Interface(), true
+}
@@ -0,0 +1,16 @@
+//go:build !go1.24
+// +build !go1.24
+
+package gcache
+
+// softValue is never created before Go 1.24, which lacks weak pointers.
+type softValue struct{}
+
+// soften returns v as is, so SoftValues has no effect.
+func soften(v interface{}) interface{} {
+	return v
+}
+
+func (sv *softValue) value() (interface{}, bool) {
+	return nil, false
+}
@@ -0,0 +1,71 @@
+//go:build go1.24
+// +build go1.24
+
+package gcache
+
+import (
+	"context"
+	"runtime"
+	"testing"
+)
+
+type softTestValue struct {
+	id  int64
+	buf [1024]byte
+}
+
+func TestSoftValues(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			var loaderCounter int64
+			cache := New(8).
+				EvictType(tp).
+				SoftValues(true).
+				LoaderFunc(func(ctx context.Context, key interface{}) (interface{}, error) {
+					loaderCounter++
+					return &softTestValue{id: loaderCounter}, nil
+				}).
+				Build()
+
+			v, err := cache.Get(defaultCtx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			held := v.(*softTestValue)
+			runtime.GC()
+			v, err = cache.Get(defaultCtx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.(*softTestValue) != held || loaderCounter != 1 {
+				t.Fatalf("referenced value should not be reclaimed, loaded %v times", loaderCounter)
+			}
+			runtime.KeepAlive(held)
+			held, v = nil, nil
+
+			runtime.GC()
+			if cache.Len(true) != 0 {
+				t.Errorf("reclaimed value should not be counted")
+			}
+			v, err = cache.Get(defaultCtx, "key")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if loaderCounter != 2 {
+				t.Errorf("%v != %v", loaderCounter, 2)
+			}
+			if id := v.(*softTestValue).id; id != 2 {
+				t.Errorf("%v != %v", id, 2)
+			}
+		})
+	}
+}
+
+func TestSoftValuesNonPointer(t *testing.T) {
+	cache := New(8).LRU().SoftValues(true).Build()
+	cache.Set("key", 1)
+	runtime.GC()
+	if v, err := cache.GetIFPresent("key"); err != nil || v != 1 {
+		t.Errorf("unexpected value %v, %v", v, err)
+	}
+}
@@ -1,7 +1,10 @@
 package gcache
 
 import (
+	"math"
+	"sync"
 	"sync/atomic"
+	"time"
 )
 
 type statsAccessor interface {
@@ -9,24 +12,157 @@ type statsAccessor interface {
 	MissCount() uint64
 	LookupCount() uint64
 	HitRate() float64
+	EvictCount() uint64
+	AverageEvictCallbackTime() time.Duration
+	PeakLen() int
+	Stats() StatsSnapshot
+}
+
+// StatsSnapshot is a point-in-time copy of the stats of a cache.
+type StatsSnapshot struct {
+	HitCount    uint64
+	MissCount   uint64
+	LookupCount uint64
+	// HitRate follows the HitRateMode of the cache.
+	HitRate float64
+	// EvictCount is the number of items which left the cache through eviction,
+	// expiration or Remove, i.e. the number of evictedFunc calls.
+	EvictCount uint64
+	// Len is the number of items in the cache, including expired ones.
+	Len int
+	// PeakLen is the highest number of items held since the stats were reset.
+	PeakLen int
+}
+
+// withTotals fills in the lookup count and hit rate from the hit and miss counts.
+func (s StatsSnapshot) withTotals() StatsSnapshot {
+	s.LookupCount = s.HitCount + s.MissCount
+	s.HitRate = 0
+	if s.LookupCount > 0 {
+		s.HitRate = float64(s.HitCount) / float64(s.LookupCount)
+	}
+	return s
+}
+
+// AggregateStats sums the stats of caches, e.g. the shards of a composite
+// cache, and computes their combined hit rate. Nil caches are skipped.
+// The summed PeakLen is an upper bound, as the caches may have peaked at
+// different times.
+func AggregateStats(caches ...Cache) StatsSnapshot {
+	var total StatsSnapshot
+	for _, c := range caches {
+		if c == nil {
+			continue
+		}
+		s := c.Stats()
+		total.HitCount += s.HitCount
+		total.MissCount += s.MissCount
+		total.EvictCount += s.EvictCount
+		total.Len += s.Len
+		total.PeakLen += s.PeakLen
+	}
+	return total.withTotals()
+}
+
+// HitRateMode is how the hit rate of a cache is computed.
+type HitRateMode struct {
+	halfLife time.Duration
+}
+
+// ModeCumulative computes the hit rate over every lookup since the stats
+// were reset.
+var ModeCumulative = HitRateMode{}
+
+// ModeExponentialDecay computes the hit rate with every lookup weighted by
+// its age, the weight halving every halfLife, so that it follows a recent
+// change in the hit pattern. A non-positive halfLife is ModeCumulative.
+func ModeExponentialDecay(halfLife time.Duration) HitRateMode {
+	return HitRateMode{halfLife: halfLife}
+}
+
+// decayedHitRate keeps the hit and lookup counts decayed to the time of the
+// last lookup.
+type decayedHitRate struct {
+	clock    Clock
+	halfLife time.Duration
+
+	mu      sync.Mutex
+	hits    float64
+	lookups float64
+	last    time.Time
+}
+
+func (d *decayedHitRate) record(hit bool) {
+	now := d.clock.Now()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if elapsed := now.Sub(d.last); elapsed > 0 && !d.last.IsZero() {
+		w := math.Exp2(-float64(elapsed) / float64(d.halfLife))
+		d.hits *= w
+		d.lookups *= w
+	}
+	if now.After(d.last) {
+		d.last = now
+	}
+	if hit {
+		d.hits++
+	}
+	d.lookups++
+}
+
+// rate returns the decayed hit rate. The decay since the last lookup applies
+// to both counts alike, so it does not change the rate.
+func (d *decayedHitRate) rate() float64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.lookups == 0 {
+		return 0
+	}
+	return d.hits / d.lookups
+}
+
+func (d *decayedHitRate) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hits, d.lookups, d.last = 0, 0, time.Time{}
 }
 
 // statistics
 type stats struct {
-	hitCount  uint64
-	missCount uint64
+	hitCount   uint64
+	missCount  uint64
+	evictCount uint64
+	peakLen    int64
+
+	evictCallbackCount uint64
+	evictCallbackNanos uint64
+
+	// decay is the decayed hit rate if the cache was built with
+	// ModeExponentialDecay. It is nil otherwise.
+	decay *decayedHitRate
 }
 
 // increment hit count
 func (st *stats) IncrHitCount() uint64 {
+	if st.decay != nil {
+		st.decay.record(true)
+	}
 	return atomic.AddUint64(&st.hitCount, 1)
 }
 
 // increment miss count
 func (st *stats) IncrMissCount() uint64 {
+	if st.decay != nil {
+		st.decay.record(false)
+	}
 	return atomic.AddUint64(&st.missCount, 1)
 }
 
+// increment evict count
+func (st *stats) IncrEvictCount() uint64 {
+	return atomic.AddUint64(&st.evictCount, 1)
+}
+
 // HitCount returns hit count
 func (st *stats) HitCount() uint64 {
 	return atomic.LoadUint64(&st.hitCount)
@@ -37,13 +173,21 @@ func (st *stats) MissCount() uint64 {
 	return atomic.LoadUint64(&st.missCount)
 }
 
+// EvictCount returns evict count
+func (st *stats) EvictCount() uint64 {
+	return atomic.LoadUint64(&st.evictCount)
+}
+
 // LookupCount returns lookup count
 func (st *stats) LookupCount() uint64 {
 	return st.HitCount() + st.MissCount()
 }
 
-// HitRate returns rate for cache hitting
+// HitRate returns rate for cache hitting, as computed by the HitRateMode
 func (st *stats) HitRate() float64 {
+	if st.decay != nil {
+		return st.decay.rate()
+	}
 	hc, mc := st.HitCount(), st.MissCount()
 	total := hc + mc
 	if total == 0 {
@@ -51,3 +195,61 @@ func (st *stats) HitRate() float64 {
 	}
 	return float64(hc) / float64(total)
 }
+
+// observeLen raises the peak length to n if n exceeds it
+func (st *stats) observeLen(n int) {
+	for {
+		peak := atomic.LoadInt64(&st.peakLen)
+		if int64(n) <= peak || atomic.CompareAndSwapInt64(&st.peakLen, peak, int64(n)) {
+			return
+		}
+	}
+}
+
+// PeakLen returns the highest number of items held since the stats were reset
+func (st *stats) PeakLen() int {
+	return int(atomic.LoadInt64(&st.peakLen))
+}
+
+// reset clears the counters and restarts the peak length at n
+func (st *stats) reset(n int) {
+	atomic.StoreUint64(&st.hitCount, 0)
+	atomic.StoreUint64(&st.missCount, 0)
+	atomic.StoreUint64(&st.evictCount, 0)
+	atomic.StoreInt64(&st.peakLen, int64(n))
+	atomic.StoreUint64(&st.evictCallbackCount, 0)
+	atomic.StoreUint64(&st.evictCallbackNanos, 0)
+	if st.decay != nil {
+		st.decay.reset()
+	}
+}
+
+// record the duration of an evictedFunc, expiredFunc or purgeVisitorFunc call
+func (st *stats) addEvictCallbackTime(d time.Duration) {
+	atomic.AddUint64(&st.evictCallbackNanos, uint64(d))
+	atomic.AddUint64(&st.evictCallbackCount, 1)
+}
+
+// AverageEvictCallbackTime returns the average duration of the evictedFunc,
+// expiredFunc and purgeVisitorFunc calls
+func (st *stats) AverageEvictCallbackTime() time.Duration {
+	n := atomic.LoadUint64(&st.evictCallbackCount)
+	if n == 0 {
+		return 0
+	}
+	return time.Duration(atomic.LoadUint64(&st.evictCallbackNanos) / n)
+}
+
+// Stats returns a snapshot of the stats
+func (st *stats) Stats() StatsSnapshot {
+	s := StatsSnapshot{
+		HitCount:   st.HitCount(),
+		MissCount:  st.MissCount(),
+		EvictCount: st.EvictCount(),
+		PeakLen:    st.PeakLen(),
+	}.withTotals()
+	if st.decay != nil {
+		s.HitRate = st.decay.rate()
+	}
+	return s
+}
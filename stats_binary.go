@@ -0,0 +1,111 @@
+package gcache
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+)
+
+// ErrStatsFrame is returned by ReadStatsBinary for a malformed frame.
+var ErrStatsFrame = errors.New("gcache: malformed stats frame")
+
+// WriteStatsBinary writes the stats of the named caches to w as a sequence
+// of length-prefixed frames, sorted by name. Each frame holds the name and
+// the hit, miss and evict counts and the length of a cache as uvarints.
+// Nil caches are skipped.
+func WriteStatsBinary(w io.Writer, caches map[string]Cache) error {
+	names := make([]string, 0, len(caches))
+	for name, c := range caches {
+		if c != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var body, frame []byte
+	for _, name := range names {
+		s := caches[name].Stats()
+		body = appendUvarint(body[:0], uint64(len(name)))
+		body = append(body, name...)
+		body = appendUvarint(body, s.HitCount)
+		body = appendUvarint(body, s.MissCount)
+		body = appendUvarint(body, s.EvictCount)
+		body = appendUvarint(body, uint64(s.Len))
+
+		frame = appendUvarint(frame[:0], uint64(len(body)))
+		frame = append(frame, body...)
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// appendUvarint appends the uvarint encoding of v to b.
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+// ReadStatsBinary reads the frames written by WriteStatsBinary from r until
+// EOF and returns the stats by cache name. PeakLen is not part of the frames
+// and is left zero.
+func ReadStatsBinary(r io.Reader) (map[string]StatsSnapshot, error) {
+	br := bufio.NewReader(r)
+	stats := make(map[string]StatsSnapshot)
+	for {
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return stats, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		body := make([]byte, n)
+		if _, err := io.ReadFull(br, body); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		name, s, err := decodeStatsFrame(body)
+		if err != nil {
+			return nil, err
+		}
+		stats[name] = s
+	}
+}
+
+// decodeStatsFrame decodes the body of a frame written by WriteStatsBinary.
+func decodeStatsFrame(body []byte) (string, StatsSnapshot, error) {
+	next := func() (uint64, bool) {
+		v, n := binary.Uvarint(body)
+		if n <= 0 {
+			return 0, false
+		}
+		body = body[n:]
+		return v, true
+	}
+
+	var s StatsSnapshot
+	nameLen, ok := next()
+	if !ok || nameLen > uint64(len(body)) {
+		return "", s, ErrStatsFrame
+	}
+	name := string(body[:nameLen])
+	body = body[nameLen:]
+
+	var fields [4]uint64
+	for i := range fields {
+		if fields[i], ok = next(); !ok {
+			return "", s, ErrStatsFrame
+		}
+	}
+	if len(body) != 0 {
+		return "", s, ErrStatsFrame
+	}
+	s.HitCount, s.MissCount, s.EvictCount, s.Len = fields[0], fields[1], fields[2], int(fields[3])
+	return name, s.withTotals(), nil
+}
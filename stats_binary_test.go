@@ -0,0 +1,60 @@
+package gcache
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestStatsBinaryRoundTrip(t *testing.T) {
+	a := New(8).LRU().Build()
+	b := New(8).LFU().Build()
+	for i := 0; i < 3; i++ {
+		a.Set(i, i)
+	}
+	a.GetIFPresent(0)
+	a.GetIFPresent(10)
+	a.Remove(1)
+	b.GetIFPresent(0)
+
+	var buf bytes.Buffer
+	if err := WriteStatsBinary(&buf, map[string]Cache{"a": a, "b": b, "nil": nil}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := ReadStatsBinary(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]StatsSnapshot{
+		"a": {HitCount: 1, MissCount: 1, LookupCount: 2, HitRate: 0.5, EvictCount: 1, Len: 2},
+		"b": {MissCount: 1, LookupCount: 1},
+	}
+	if len(stats) != len(expected) {
+		t.Fatalf("%v != %v", stats, expected)
+	}
+	for name, s := range expected {
+		if stats[name] != s {
+			t.Errorf("%v: %v != %v", name, stats[name], s)
+		}
+	}
+}
+
+func TestReadStatsBinaryTruncated(t *testing.T) {
+	a := New(8).LRU().Build()
+	a.Set(1, 1)
+
+	var buf bytes.Buffer
+	if err := WriteStatsBinary(&buf, map[string]Cache{"a": a}); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	if _, err := ReadStatsBinary(bytes.NewReader(data[:len(data)-1])); err != io.ErrUnexpectedEOF {
+		t.Errorf("%v != %v", err, io.ErrUnexpectedEOF)
+	}
+	// A frame whose body has trailing bytes is rejected.
+	bad := append([]byte{byte(data[0] + 1)}, data[1:]...)
+	bad = append(bad, 0)
+	if _, err := ReadStatsBinary(bytes.NewReader(bad)); err != ErrStatsFrame {
+		t.Errorf("%v != %v", err, ErrStatsFrame)
+	}
+}
@@ -2,7 +2,11 @@ package gcache
 
 import (
 	"context"
+	"fmt"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestStats(t *testing.T) {
@@ -156,3 +160,233 @@ func TestLoadingCacheStats(t *testing.T) {
 		}
 	}
 }
+
+func TestCountExistedInStats(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		for _, count := range []bool{false, true} {
+			cc := New(32).
+				EvictType(tp).
+				CountExistedInStats(count).
+				Build()
+			cc.Set(0, 0)
+			cc.Existed(0)
+			cc.Existed(1)
+			var expected uint64
+			if count {
+				expected = 1
+			}
+			if hc := cc.HitCount(); hc != expected {
+				t.Errorf("%v(count=%v): %v != %v", tp, count, hc, expected)
+			}
+			if mc := cc.MissCount(); mc != expected {
+				t.Errorf("%v(count=%v): %v != %v", tp, count, mc, expected)
+			}
+		}
+	}
+}
+
+func TestAggregateStats(t *testing.T) {
+	a := New(8).LRU().Build()
+	b := New(8).LFU().Build()
+	a.Set("x", 1)
+	b.Set("x", 1)
+	for i := 0; i < 3; i++ {
+		a.GetIFPresent("x")
+	}
+	a.GetIFPresent("y")
+	b.GetIFPresent("x")
+	for i := 0; i < 3; i++ {
+		b.GetIFPresent("y")
+	}
+
+	s := AggregateStats(a, nil, b)
+	expected := StatsSnapshot{HitCount: 4, MissCount: 4, LookupCount: 8, HitRate: 0.5, Len: 2, PeakLen: 2}
+	if s != expected {
+		t.Errorf("%v != %v", s, expected)
+	}
+	if s := AggregateStats(); s != (StatsSnapshot{}) {
+		t.Errorf("%v != %v", s, StatsSnapshot{})
+	}
+}
+
+func TestPeakLen(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			gc := New(4).EvictType(tp).Build()
+			for i := 0; i < 3; i++ {
+				gc.Set(i, i)
+			}
+			gc.Remove(0)
+			gc.Remove(1)
+			gc.Set(3, 3)
+			if n := gc.PeakLen(); n != 3 {
+				t.Errorf("%v != %v", n, 3)
+			}
+			// Evictions keep the cache within its size.
+			for i := 4; i < 10; i++ {
+				gc.Set(i, i)
+			}
+			if n := gc.Stats().PeakLen; n != 4 {
+				t.Errorf("%v != %v", n, 4)
+			}
+
+			gc.GetIFPresent(9)
+			gc.Remove(9)
+			gc.ResetStats()
+			if s := gc.Stats(); s != (StatsSnapshot{Len: 3, PeakLen: 3}) {
+				t.Errorf("%v != %v", s, StatsSnapshot{Len: 3, PeakLen: 3})
+			}
+		})
+	}
+}
+
+type recordingLogger struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+func TestEvictCallbackTime(t *testing.T) {
+	logger := &recordingLogger{}
+	gc := New(1).
+		LRU().
+		Logger(logger).
+		SlowCallbackThreshold(5 * time.Millisecond).
+		EvictedFunc(func(key, value interface{}) {
+			if key == "slow" {
+				time.Sleep(20 * time.Millisecond)
+			}
+		}).
+		Build()
+	if d := gc.AverageEvictCallbackTime(); d != 0 {
+		t.Errorf("%v != %v", d, 0)
+	}
+
+	gc.Set("fast", 1)
+	gc.Set("slow", 2)
+	gc.Set("other", 3)
+	if d := gc.AverageEvictCallbackTime(); d < 10*time.Millisecond {
+		t.Errorf("average callback time %v should be at least %v", d, 10*time.Millisecond)
+	}
+	if len(logger.msgs) != 1 || !strings.Contains(logger.msgs[0], "evictedFunc for key slow") {
+		t.Errorf("unexpected warnings %q", logger.msgs)
+	}
+
+	gc.ResetStats()
+	if d := gc.AverageEvictCallbackTime(); d != 0 {
+		t.Errorf("%v != %v", d, 0)
+	}
+}
+
+func TestHitRateMode(t *testing.T) {
+	fc := NewFakeClock()
+	cumulative := New(8).Clock(fc).Build()
+	decayed := New(8).Clock(fc).HitRateMode(ModeExponentialDecay(time.Minute)).Build()
+	for _, gc := range []Cache{cumulative, decayed} {
+		gc.Set("x", 1)
+	}
+
+	// A long period of hits followed by a recent period of misses.
+	for i := 0; i < 100; i++ {
+		for _, gc := range []Cache{cumulative, decayed} {
+			gc.GetIFPresent("x")
+		}
+		fc.Advance(time.Second)
+	}
+	fc.Advance(10 * time.Minute)
+	for i := 0; i < 10; i++ {
+		for _, gc := range []Cache{cumulative, decayed} {
+			gc.GetIFPresent("y")
+		}
+		fc.Advance(time.Second)
+	}
+
+	if r := cumulative.HitRate(); r < 0.9 {
+		t.Errorf("the cumulative hit rate %v should be at least 0.9", r)
+	}
+	if r := decayed.HitRate(); r > 0.1 {
+		t.Errorf("the decayed hit rate %v should be at most 0.1", r)
+	}
+	if r := decayed.Stats().HitRate; r != decayed.HitRate() {
+		t.Errorf("%v != %v", r, decayed.HitRate())
+	}
+
+	decayed.ResetStats()
+	if r := decayed.HitRate(); r != 0 {
+		t.Errorf("%v != %v", r, 0)
+	}
+	decayed.GetIFPresent("x")
+	if r := decayed.HitRate(); r != 1 {
+		t.Errorf("%v != %v", r, 1)
+	}
+}
+
+func TestWarnOnZeroHits(t *testing.T) {
+	logger := &recordingLogger{}
+	gc := New(8).LRU().Logger(logger).WarnOnZeroHits(10).Build()
+	// Written under int keys, read under string keys.
+	for i := 0; i < 50; i++ {
+		gc.Set(i%5, i)
+		gc.GetIFPresent(fmt.Sprint(i % 5))
+		if i == 8 && len(logger.msgs) != 0 {
+			t.Fatalf("warned before 10 lookups: %v", logger.msgs)
+		}
+	}
+	if n := len(logger.msgs); n != 1 {
+		t.Errorf("%v != %v", n, 1)
+	}
+
+	// A cache which hits does not warn.
+	logger = &recordingLogger{}
+	gc = New(8).LRU().Logger(logger).WarnOnZeroHits(10).Build()
+	gc.Set(0, 0)
+	gc.GetIFPresent(0)
+	for i := 0; i < 50; i++ {
+		gc.GetIFPresent(1)
+	}
+	if n := len(logger.msgs); n != 0 {
+		t.Errorf("%v != %v", n, 0)
+	}
+}
+
+func TestEvictionStats(t *testing.T) {
+	// The victims are deterministic for these policies.
+	for _, tp := range []string{TypeSimple, TypeLru, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			fc := NewFakeClock()
+			gc := New(2).EvictType(tp).Clock(fc).TrackEvictionStats(2).Build()
+			gc.Set("a", 1)
+			fc.Advance(10 * time.Second)
+			gc.Set("b", 2)
+			fc.Advance(10 * time.Second)
+			// a is evicted after 20s without having been read.
+			gc.Set("c", 3)
+			expected := EvictionStatsSnapshot{Evictions: 1, AverageAge: 20 * time.Second}
+			if s := gc.EvictionStats(); s != expected {
+				t.Errorf("%+v != %+v", s, expected)
+			}
+
+			// b is evicted after 10s, having been read within the last two reads.
+			gc.GetIFPresent("b")
+			gc.GetIFPresent("c")
+			gc.Set("d", 4)
+			expected = EvictionStatsSnapshot{Evictions: 2, AverageAge: 15 * time.Second, RecentlyReadFraction: 0.5}
+			if s := gc.EvictionStats(); s != expected {
+				t.Errorf("%+v != %+v", s, expected)
+			}
+		})
+	}
+
+	gc := New(2).LRU().Build()
+	gc.Set(1, 1)
+	gc.Set(2, 2)
+	gc.Set(3, 3)
+	if s := gc.EvictionStats(); s != (EvictionStatsSnapshot{}) {
+		t.Errorf("%+v != %+v", s, EvictionStatsSnapshot{})
+	}
+}
@@ -0,0 +1,96 @@
+package gcache
+
+// tagIndex maps tags to the keys set with them, and back. Keys are stored
+// encoded. It is guarded by the cache lock.
+type tagIndex struct {
+	keys map[string]map[interface{}]struct{}
+	tags map[interface{}][]string
+}
+
+func newTagIndex() *tagIndex {
+	return &tagIndex{
+		keys: make(map[string]map[interface{}]struct{}),
+		tags: make(map[interface{}][]string),
+	}
+}
+
+// tag replaces the tags of key.
+func (t *tagIndex) tag(key interface{}, tags []string) {
+	t.untag(key)
+	if len(tags) == 0 {
+		return
+	}
+	for _, tag := range tags {
+		keys, ok := t.keys[tag]
+		if !ok {
+			keys = make(map[interface{}]struct{})
+			t.keys[tag] = keys
+		}
+		keys[key] = struct{}{}
+	}
+	t.tags[key] = append([]string(nil), tags...)
+}
+
+// untag removes key from the index.
+func (t *tagIndex) untag(key interface{}) {
+	for _, tag := range t.tags[key] {
+		keys := t.keys[tag]
+		delete(keys, key)
+		if len(keys) == 0 {
+			delete(t.keys, tag)
+		}
+	}
+	delete(t.tags, key)
+}
+
+// SetWithTags sets a new key-value pair and replaces the tags of key, so that
+// InvalidateTag can remove it together with the other keys of a tag. Set
+// keeps the tags of an existing key. The tags are dropped when the key is
+// removed, evicted or expires.
+func (c *baseCache) SetWithTags(key, value interface{}, tags ...string) error {
+	if err := c.checkKeyType(key); err != nil {
+		return err
+	}
+	key = c.encodeKey(key)
+	c.mu.Lock()
+	defer c.unlock()
+	c.invalidateLoad(key, loadOverwritten)
+	if _, err := c.cache.set(key, value); err != nil {
+		return err
+	}
+	if c.tags == nil {
+		c.tags = newTagIndex()
+	}
+	c.tags.tag(key, tags)
+	return nil
+}
+
+// InvalidateTag removes the items set with tag and returns the number of
+// items removed.
+func (c *baseCache) InvalidateTag(tag string) int {
+	c.mu.Lock()
+	defer c.unlock()
+	if c.tags == nil {
+		return 0
+	}
+	keys := make([]interface{}, 0, len(c.tags.keys[tag]))
+	for key := range c.tags.keys[tag] {
+		keys = append(keys, key)
+	}
+	removed := 0
+	for _, key := range keys {
+		if c.recordRemove(key, c.cache.remove(key)) {
+			removed++
+		}
+		// remove untags the key through notifyEvicted, unless it was gone.
+		c.tags.untag(key)
+	}
+	return removed
+}
+
+// untag drops the tags of key, which left the cache.
+func (c *baseCache) untag(key interface{}) {
+	if c.tags != nil {
+		c.tags.untag(key)
+	}
+}
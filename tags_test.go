@@ -0,0 +1,123 @@
+package gcache
+
+import (
+	"testing"
+	"time"
+)
+
+// tagIndexOf returns the tag index of gc.
+func tagIndexOf(gc Cache) *tagIndex {
+	switch c := gc.(type) {
+	case *simpleCache:
+		return c.tags
+	case *lruCache:
+		return c.tags
+	case *lfuCache:
+		return c.tags
+	case *arcCache:
+		return c.tags
+	}
+	return nil
+}
+
+// checkTagIndex checks that the tag index of gc only holds keys in gc.
+func checkTagIndex(t *testing.T, gc Cache) {
+	t.Helper()
+	idx := tagIndexOf(gc)
+	if idx == nil {
+		return
+	}
+	for key, tags := range idx.tags {
+		if !gc.Existed(key) {
+			t.Errorf("%v with tags %v is not in the cache", key, tags)
+		}
+	}
+	for tag, keys := range idx.keys {
+		if len(keys) == 0 {
+			t.Errorf("tag %v has no keys", tag)
+		}
+	}
+}
+
+func TestInvalidateTag(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			gc := New(10).EvictType(tp).Build()
+			gc.SetWithTags("user:1", 1, "org1")
+			gc.SetWithTags("user:2", 2, "org1", "admins")
+			gc.SetWithTags("user:3", 3, "org2")
+			gc.Set("plain", 4)
+
+			if n := gc.InvalidateTag("org1"); n != 2 {
+				t.Errorf("%v != %v", n, 2)
+			}
+			for _, key := range []string{"user:1", "user:2"} {
+				if gc.Existed(key) {
+					t.Errorf("%v should be removed", key)
+				}
+			}
+			for _, key := range []string{"user:3", "plain"} {
+				if !gc.Existed(key) {
+					t.Errorf("%v should be kept", key)
+				}
+			}
+			if n := gc.InvalidateTag("admins"); n != 0 {
+				t.Errorf("%v != %v", n, 0)
+			}
+			if n := gc.InvalidateTag("unknown"); n != 0 {
+				t.Errorf("%v != %v", n, 0)
+			}
+
+			// SetWithTags replaces the tags, Set keeps them.
+			gc.SetWithTags("user:3", 3, "org3")
+			gc.Set("user:3", 30)
+			if n := gc.InvalidateTag("org2"); n != 0 {
+				t.Errorf("%v != %v", n, 0)
+			}
+			if n := gc.InvalidateTag("org3"); n != 1 {
+				t.Errorf("%v != %v", n, 1)
+			}
+			if idx := tagIndexOf(gc); len(idx.keys) != 0 || len(idx.tags) != 0 {
+				t.Errorf("tag index not empty: %v %v", idx.keys, idx.tags)
+			}
+		})
+	}
+}
+
+func TestTagIndexCleanup(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			clock := NewFakeClock()
+			gc := New(2).EvictType(tp).Clock(clock).Build()
+
+			// Eviction.
+			gc.SetWithTags("a", 1, "t")
+			gc.SetWithTags("b", 2, "t")
+			for i := 0; i < 4; i++ {
+				gc.Set(i, i)
+			}
+			checkTagIndex(t, gc)
+
+			// Removal.
+			gc.SetWithTags("c", 3, "t")
+			gc.Remove("c")
+			checkTagIndex(t, gc)
+
+			// Expiry.
+			gc.SetWithTags("d", 4, "t")
+			gc.SetWithExpire("d", 4, time.Second)
+			clock.Advance(2 * time.Second)
+			if _, err := gc.GetIFPresent("d"); err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			checkTagIndex(t, gc)
+
+			// Purge.
+			gc.SetWithTags("e", 5, "t")
+			gc.Purge()
+			if n := gc.InvalidateTag("t"); n != 0 {
+				t.Errorf("%v != %v", n, 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,93 @@
+package gcache
+
+// Tx is a view of the cache inside Transaction. Set and Remove are buffered
+// until the transaction commits, and Get sees the buffered writes.
+type Tx interface {
+	Get(key interface{}) (interface{}, error)
+	Set(key, value interface{}) error
+	Remove(key interface{}) bool
+}
+
+type txWrite struct {
+	key     interface{}
+	value   interface{}
+	removed bool
+}
+
+type tx struct {
+	c      *baseCache
+	writes []txWrite
+	latest map[interface{}]int
+}
+
+// Get returns the value for key as of the buffered writes, without updating
+// the hit/miss stats or the eviction order.
+func (t *tx) Get(key interface{}) (interface{}, error) {
+	key = t.c.encodeKey(key)
+	if i, ok := t.latest[key]; ok {
+		if t.writes[i].removed {
+			return nil, ErrKeyNotFound
+		}
+		return t.writes[i].value, nil
+	}
+	item := t.c.cache.peek(key)
+	if item == nil {
+		return nil, ErrKeyNotFound
+	}
+	v, ok := item.liveValue(nil)
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return t.c.deserialize(key, v)
+}
+
+func (t *tx) Set(key, value interface{}) error {
+	t.write(txWrite{key: t.c.encodeKey(key), value: value})
+	return nil
+}
+
+// Remove buffers the removal of key and reports whether it was present.
+func (t *tx) Remove(key interface{}) bool {
+	_, err := t.Get(key)
+	t.write(txWrite{key: t.c.encodeKey(key), removed: true})
+	return err == nil
+}
+
+func (t *tx) write(w txWrite) {
+	t.latest[w.key] = len(t.writes)
+	t.writes = append(t.writes, w)
+}
+
+// Transaction runs fn under the cache lock and applies its writes if it returns nil.
+// All the values are serialized before any write is applied, so that a value
+// which fails to serialize leaves the cache unchanged.
+func (c *baseCache) Transaction(fn func(tx Tx) error) error {
+	c.mu.Lock()
+	defer c.unlock()
+	t := &tx{c: c, latest: make(map[interface{}]int)}
+	if err := fn(t); err != nil {
+		return err
+	}
+	for i, w := range t.writes {
+		if w.removed {
+			continue
+		}
+		v, err := c.serialize(w.key, w.value)
+		if err != nil {
+			return err
+		}
+		t.writes[i].value = serialized{v}
+	}
+	for _, w := range t.writes {
+		if w.removed {
+			c.cache.remove(w.key)
+			c.invalidateLoad(w.key, loadRemoved)
+			continue
+		}
+		c.invalidateLoad(w.key, loadOverwritten)
+		if _, err := c.cache.set(w.key, w.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
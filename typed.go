@@ -0,0 +1,232 @@
+//go:build go1.21
+// +build go1.21
+
+package gcache
+
+import (
+	"context"
+	"time"
+)
+
+// TypedCache is a Cache whose keys are of type K and values of type V.
+type TypedCache[K comparable, V any] interface {
+	// Get a value from cache pool using key if it exists. If not exists and it has a
+	// loader, it will generate the value using the loader. It returns the zero value
+	// of V and ErrKeyNotFound for a missing key.
+	Get(ctx context.Context, key K) (V, error)
+
+	// GetIFPresent gets a value from cache pool using key if it exists. It returns
+	// the zero value of V and ErrKeyNotFound for a missing key.
+	GetIFPresent(key K) (V, error)
+
+	// Set a new key-value pair
+	Set(key K, value V) error
+
+	// SetWithExpire Set a new key-value pair with an expiration time.
+	SetWithExpire(key K, value V, expiration time.Duration) error
+
+	// GetALL returns all key-value pairs in the cache.
+	GetALL(checkExpired bool) map[K]V
+
+	// Keys returns a slice of the keys in the cache.
+	Keys(checkExpired bool) []K
+
+	// Existed checks if key exists in cache
+	Existed(key K) bool
+
+	// Remove removes the provided key from the cache.
+	Remove(key K) bool
+
+	// Len returns the number of items in the cache.
+	Len(checkExpired bool) int
+
+	// Completely clear the cache
+	Purge()
+
+	// Untyped returns the underlying cache. Values set through it must be of
+	// type V, or they read as the zero value of V.
+	Untyped() LoadingCache
+}
+
+// TypedCacheBuilder builds a TypedCache. It mirrors CacheBuilder.
+type TypedCacheBuilder[K comparable, V any] struct {
+	cb *CacheBuilder
+}
+
+// NewTyped returns a builder of a TypedCache of the given size.
+func NewTyped[K comparable, V any](size int) *TypedCacheBuilder[K, V] {
+	return &TypedCacheBuilder[K, V]{cb: New(size)}
+}
+
+func (tb *TypedCacheBuilder[K, V]) Clock(clock Clock) *TypedCacheBuilder[K, V] {
+	tb.cb.Clock(clock)
+	return tb
+}
+
+func (tb *TypedCacheBuilder[K, V]) EvictType(tp string) *TypedCacheBuilder[K, V] {
+	tb.cb.EvictType(tp)
+	return tb
+}
+
+func (tb *TypedCacheBuilder[K, V]) Simple() *TypedCacheBuilder[K, V] {
+	return tb.EvictType(TypeSimple)
+}
+
+func (tb *TypedCacheBuilder[K, V]) LRU() *TypedCacheBuilder[K, V] {
+	return tb.EvictType(TypeLru)
+}
+
+func (tb *TypedCacheBuilder[K, V]) LFU() *TypedCacheBuilder[K, V] {
+	return tb.EvictType(TypeLfu)
+}
+
+func (tb *TypedCacheBuilder[K, V]) ARC() *TypedCacheBuilder[K, V] {
+	return tb.EvictType(TypeArc)
+}
+
+func (tb *TypedCacheBuilder[K, V]) Expiration(expiration time.Duration) *TypedCacheBuilder[K, V] {
+	tb.cb.Expiration(expiration)
+	return tb
+}
+
+// MaxIdle expires items which have not been read for maxIdle.
+func (tb *TypedCacheBuilder[K, V]) MaxIdle(maxIdle time.Duration) *TypedCacheBuilder[K, V] {
+	tb.cb.MaxIdle(maxIdle)
+	return tb
+}
+
+// Set a loader function.
+// loaderFunc: create a new value with this function if cached value is expired.
+func (tb *TypedCacheBuilder[K, V]) LoaderFunc(loaderFunc func(context.Context, K) (V, error)) *TypedCacheBuilder[K, V] {
+	tb.cb.LoaderFunc(func(ctx context.Context, k interface{}) (interface{}, error) {
+		return loaderFunc(ctx, k.(K))
+	})
+	return tb
+}
+
+// Set a loader function with expiration.
+// If nil returned instead of time.Duration from loaderExpireFunc than value will never expire.
+func (tb *TypedCacheBuilder[K, V]) LoaderExpireFunc(loaderExpireFunc func(context.Context, K) (V, *time.Duration, error)) *TypedCacheBuilder[K, V] {
+	tb.cb.LoaderExpireFunc(func(ctx context.Context, k interface{}) (interface{}, *time.Duration, error) {
+		return loaderExpireFunc(ctx, k.(K))
+	})
+	return tb
+}
+
+func (tb *TypedCacheBuilder[K, V]) EvictedFunc(evictedFunc func(K, V)) *TypedCacheBuilder[K, V] {
+	tb.cb.EvictedFunc(typedCallback(evictedFunc))
+	return tb
+}
+
+// ExpiredFunc sets a callback called, after evictedFunc, for the items which
+// are removed because they expired.
+func (tb *TypedCacheBuilder[K, V]) ExpiredFunc(expiredFunc func(K, V)) *TypedCacheBuilder[K, V] {
+	tb.cb.ExpiredFunc(typedCallback(expiredFunc))
+	return tb
+}
+
+func (tb *TypedCacheBuilder[K, V]) PurgeVisitorFunc(purgeVisitorFunc func(K, V)) *TypedCacheBuilder[K, V] {
+	tb.cb.PurgeVisitorFunc(typedCallback(purgeVisitorFunc))
+	return tb
+}
+
+func (tb *TypedCacheBuilder[K, V]) AddedFunc(addedFunc func(K, V)) *TypedCacheBuilder[K, V] {
+	tb.cb.AddedFunc(typedCallback(addedFunc))
+	return tb
+}
+
+func (tb *TypedCacheBuilder[K, V]) Build() TypedCache[K, V] {
+	if err := tb.cb.validate(); err != nil {
+		panic(err)
+	}
+	return &typedCache[K, V]{c: tb.cb.build()}
+}
+
+// typedCallback adapts fn to the untyped callbacks of CacheBuilder.
+func typedCallback[K comparable, V any](fn func(K, V)) func(interface{}, interface{}) {
+	if fn == nil {
+		return nil
+	}
+	return func(k, v interface{}) {
+		fn(k.(K), typedValue[V](v))
+	}
+}
+
+// typedValue returns v as a V, or the zero value of V if it is not one, e.g.
+// because it is a nil interface.
+func typedValue[V any](v interface{}) V {
+	typed, _ := v.(V)
+	return typed
+}
+
+type typedCache[K comparable, V any] struct {
+	c LoadingCache
+}
+
+func (t *typedCache[K, V]) Get(ctx context.Context, key K) (V, error) {
+	v, err := t.c.Get(ctx, key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return typedValue[V](v), nil
+}
+
+func (t *typedCache[K, V]) GetIFPresent(key K) (V, error) {
+	v, err := t.c.GetIFPresent(key)
+	if err != nil {
+		var zero V
+		return zero, err
+	}
+	return typedValue[V](v), nil
+}
+
+func (t *typedCache[K, V]) Set(key K, value V) error {
+	return t.c.Set(key, value)
+}
+
+func (t *typedCache[K, V]) SetWithExpire(key K, value V, expiration time.Duration) error {
+	return t.c.SetWithExpire(key, value, expiration)
+}
+
+func (t *typedCache[K, V]) GetALL(checkExpired bool) map[K]V {
+	all := t.c.GetALL(checkExpired)
+	items := make(map[K]V, len(all))
+	for k, v := range all {
+		if key, ok := k.(K); ok {
+			items[key] = typedValue[V](v)
+		}
+	}
+	return items
+}
+
+func (t *typedCache[K, V]) Keys(checkExpired bool) []K {
+	all := t.c.Keys(checkExpired)
+	keys := make([]K, 0, len(all))
+	for _, k := range all {
+		if key, ok := k.(K); ok {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func (t *typedCache[K, V]) Existed(key K) bool {
+	return t.c.Existed(key)
+}
+
+func (t *typedCache[K, V]) Remove(key K) bool {
+	return t.c.Remove(key)
+}
+
+func (t *typedCache[K, V]) Len(checkExpired bool) int {
+	return t.c.Len(checkExpired)
+}
+
+func (t *typedCache[K, V]) Purge() {
+	t.c.Purge()
+}
+
+func (t *typedCache[K, V]) Untyped() LoadingCache {
+	return t.c
+}
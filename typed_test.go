@@ -0,0 +1,134 @@
+//go:build go1.21
+// +build go1.21
+
+package gcache
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+type typedUser struct {
+	ID   int
+	Name string
+}
+
+func TestTypedCacheStructValues(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			gc := NewTyped[int, typedUser](10).EvictType(tp).Build()
+			for i := 0; i < 3; i++ {
+				if err := gc.Set(i, typedUser{ID: i, Name: fmt.Sprint("user", i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			u, err := gc.GetIFPresent(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := (typedUser{ID: 1, Name: "user1"}); u != expected {
+				t.Errorf("%v != %v", u, expected)
+			}
+
+			u, err = gc.GetIFPresent(10)
+			if err != ErrKeyNotFound {
+				t.Errorf("%v != %v", err, ErrKeyNotFound)
+			}
+			if u != (typedUser{}) {
+				t.Errorf("%v != %v", u, typedUser{})
+			}
+
+			all := gc.GetALL(true)
+			if len(all) != 3 {
+				t.Errorf("%v != %v", len(all), 3)
+			}
+			for k, v := range all {
+				if v.ID != k {
+					t.Errorf("%v != %v", v.ID, k)
+				}
+			}
+			keys := gc.Keys(true)
+			sum := 0
+			for _, k := range keys {
+				sum += k
+			}
+			if sum != 0+1+2 {
+				t.Errorf("%v != %v", sum, 3)
+			}
+		})
+	}
+}
+
+func TestTypedCachePointerValues(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			gc := NewTyped[int, *typedUser](10).EvictType(tp).Build()
+			u := &typedUser{ID: 1}
+			gc.Set(1, u)
+			got, err := gc.GetIFPresent(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != u {
+				t.Errorf("%p != %p", got, u)
+			}
+
+			gc.Set(2, nil)
+			got, err = gc.GetIFPresent(2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != nil {
+				t.Errorf("%v != nil", got)
+			}
+
+			got, err = gc.GetIFPresent(3)
+			if err != ErrKeyNotFound || got != nil {
+				t.Errorf("%v, %v != nil, %v", got, err, ErrKeyNotFound)
+			}
+		})
+	}
+}
+
+func TestTypedCacheLoader(t *testing.T) {
+	for _, tp := range []string{TypeSimple, TypeLru, TypeLfu, TypeArc} {
+		t.Run(tp, func(t *testing.T) {
+			var evicted []int
+			gc := NewTyped[int, *typedUser](1).
+				EvictType(tp).
+				LoaderFunc(func(ctx context.Context, id int) (*typedUser, error) {
+					if id < 0 {
+						return nil, ErrKeyNotFound
+					}
+					return &typedUser{ID: id}, nil
+				}).
+				EvictedFunc(func(id int, u *typedUser) {
+					if u.ID != id {
+						t.Errorf("%v != %v", u.ID, id)
+					}
+					evicted = append(evicted, id)
+				}).
+				Build()
+
+			u, err := gc.Get(context.Background(), 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if u.ID != 1 {
+				t.Errorf("%v != %v", u.ID, 1)
+			}
+			if _, err := gc.Get(context.Background(), 2); err != nil {
+				t.Fatal(err)
+			}
+			if len(evicted) != 1 || evicted[0] != 1 {
+				t.Errorf("%v != %v", evicted, []int{1})
+			}
+
+			u, err = gc.Get(context.Background(), -1)
+			if err != ErrKeyNotFound || u != nil {
+				t.Errorf("%v, %v != nil, %v", u, err, ErrKeyNotFound)
+			}
+		})
+	}
+}